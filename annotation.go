@@ -0,0 +1,141 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// annotation
+// collect xs:documentation and xs:appinfo and attach them to their component
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// documentation and appinfo attached to a component
+type annotations struct {
+	doc     string
+	appinfo []string
+}
+
+// an xs:documentation or xs:appinfo being collected
+// its content is never treated as schema components
+type annotationState struct {
+	depth  int    // nesting within documentation / appinfo, 0 if outside
+	kind   string // documentation | appinfo
+	text   strings.Builder
+	target func() *annotations // nil if the annotated component is not kept
+}
+
+// the components whose annotations are kept
+var annotated = map[string]bool{
+	"element":     true,
+	"attribute":   true,
+	"simpleType":  true,
+	"complexType": true,
+	"enumeration": true,
+}
+
+// start an xs:annotation: find the component it belongs to
+func startAnnotation(ctxt *context) {
+	ctxt.annot.target = nil
+	n := len(ctxt.xsdStack)
+	if n == 0 || !annotated[ctxt.xsdStack[n-1]] || len(ctxt.frames) == 0 {
+		return
+	}
+	ctxt.annot.target = ctxt.frames[len(ctxt.frames)-1].getAnn
+}
+
+// start collecting an xs:documentation or xs:appinfo
+func startAnnotationContent(kind string, ctxt *context) {
+	ctxt.annot.depth = 1
+	ctxt.annot.kind = kind
+	ctxt.annot.text.Reset()
+}
+
+// an element nested in documentation or appinfo
+// appinfo markup is kept, documentation (e.g. XHTML) contributes only its text
+func annotationStart(el *xml.StartElement, ctxt *context) {
+	ctxt.annot.depth++
+	if ctxt.annot.kind == "appinfo" {
+		ctxt.annot.text.WriteString("<" + el.Name.Local)
+		for _, attr := range el.Attr {
+			if attr.Name.Space != "xmlns" && attr.Name.Local != "xmlns" {
+				fmt.Fprintf(&ctxt.annot.text, " %s=\"%s\"", attr.Name.Local, xmlEscape(attr.Value))
+			}
+		}
+		ctxt.annot.text.WriteString(">")
+	}
+}
+
+// the end of an element within (or the end of) documentation or appinfo
+func annotationEnd(el *xml.EndElement, ctxt *context) {
+	ctxt.annot.depth--
+	if ctxt.annot.depth > 0 {
+		if ctxt.annot.kind == "appinfo" {
+			ctxt.annot.text.WriteString("</" + el.Name.Local + ">")
+		}
+		return
+	}
+	text := strings.Join(strings.Fields(ctxt.annot.text.String()), " ")
+	if text == "" || ctxt.annot.target == nil {
+		return
+	}
+	ann := ctxt.annot.target()
+	if ctxt.annot.kind == "appinfo" {
+		ann.appinfo = append(ann.appinfo, text)
+	} else if ann.doc == "" {
+		ann.doc = text
+	} else {
+		ann.doc += " " + text
+	}
+}
+
+// text within documentation or appinfo
+func annotationText(text xml.CharData, ctxt *context) {
+	if ctxt.annot.kind == "appinfo" {
+		ctxt.annot.text.WriteString(xmlEscape(string(text)))
+	} else {
+		ctxt.annot.text.Write(text)
+	}
+}
+
+// escape text for reconstructing appinfo markup
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;")
+
+func xmlEscape(s string) string {
+	return xmlEscaper.Replace(s)
+}
+
+// are there any annotations to write?
+func (ann annotations) isEmpty() bool {
+	return ann.doc == "" && len(ann.appinfo) == 0
+}
+
+// write documentation as "description" and appinfo as "x-appinfo"
+func writeAnnotations(ann annotations, f io.Writer, ctxt *context, indent int) {
+	if ann.doc != "" {
+		inPrintf(f, indent, "\"description\": \"%s\",\n", jsonEscape(ann.doc))
+	}
+	if len(ann.appinfo) > 0 {
+		escaped := make([]string, len(ann.appinfo))
+		for i, info := range ann.appinfo {
+			escaped[i] = jsonEscape(info)
+		}
+		inPrintf(f, indent, "\"x-appinfo\": %s,\n", arrayString(escaped))
+	}
+}
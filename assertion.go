@@ -0,0 +1,583 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// assertion
+// translate the common subset of XSD 1.1 xs:assert and xs:assertion tests
+// into JSON schema; anything else is kept as "x-assert"
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// an xs:assert or xs:assertion
+type assertion struct {
+	test   string
+	schema string // the translated schema, without its braces; "" if not translated
+}
+
+// translate the assertions of every type, once everything is resolved
+// the assertions are copied first, as a derived type may share its base's
+func translateAssertions(ctxt *context) {
+	for qn, cmplx := range ctxt.complexTypes {
+		maxes := make(map[string]int64)
+		elemMaxOccurs(cmplx.content, 1, cmplx, maxes, ctxt)
+		cmplx.asserts = append(make([]assertion, 0), cmplx.asserts...)
+		for i, a := range cmplx.asserts {
+			if cmplx.mixed && ctxt.mixedOrdered {
+				diagnoseComponent(ctxt, sevWarning, typeNode{name: qn}, "assertion %s not translated: ordered mixed content", a.test)
+				continue
+			}
+			tr := assertTranslator{ctxt: ctxt, cmplx: &cmplx, maxes: maxes}
+			cmplx.asserts[i].schema = tr.translate(a.test, typeNode{name: qn})
+		}
+		ctxt.complexTypes[qn] = cmplx
+	}
+	for qn, simple := range ctxt.simpleTypes {
+		simple.asserts = append(make([]assertion, 0), simple.asserts...)
+		for i, a := range simple.asserts {
+			if simple.variety == "list" || simple.variety == "union" {
+				diagnoseComponent(ctxt, sevWarning, typeNode{name: qn}, "assertion %s not translated: %s type", a.test, simple.variety)
+				continue
+			}
+			tr := assertTranslator{ctxt: ctxt, simple: &simple}
+			simple.asserts[i].schema = tr.translate(a.test, typeNode{name: qn})
+		}
+		ctxt.simpleTypes[qn] = simple
+	}
+}
+
+// translates the tests of one type
+// the tests of a complex type refer to its children, e.g. "@Ccy" or "Cd",
+// those of a simple type to $value and any attributes
+type assertTranslator struct {
+	ctxt   *context
+	cmplx  *complexType
+	simple *simpleType
+	maxes  map[string]int64 // maxOccurs of the children, see elemMaxOccurs
+	tokens []string
+	pos    int
+}
+
+// translate a test, reporting it if it can't be
+func (tr *assertTranslator) translate(test string, component typeNode) string {
+	tokens, err := xpathTokens(test)
+	if err == nil {
+		tr.tokens, tr.pos = tokens, 0
+		var schema string
+		schema, err = tr.expr()
+		if err == nil && tr.pos < len(tr.tokens) {
+			err = fmt.Errorf("unexpected %s", tr.tokens[tr.pos])
+		}
+		if err == nil {
+			if schema == "" {
+				schema = "\"$comment\": \"always true\""
+			}
+			return schema
+		}
+	}
+	diagnoseComponent(tr.ctxt, sevWarning, component, "assertion %s not translated: %v", test, err)
+	return ""
+}
+
+// split an XPath expression into names, literals and operators
+func xpathTokens(s string) ([]string, error) {
+	tokens := make([]string, 0)
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexRune(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(s[i:], "!=") || strings.HasPrefix(s[i:], "<=") || strings.HasPrefix(s[i:], ">="):
+			tokens = append(tokens, s[i:i+2])
+			i += 2
+		case strings.ContainsRune("()=<>,@", c):
+			tokens = append(tokens, string(c))
+			i++
+		case c == '$' || c == '-' || c == '.' || c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(s) && (strings.ContainsRune("-._:", rune(s[j])) || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unsupported %c", c)
+		}
+	}
+	return tokens, nil
+}
+
+// the next token, "" at the end
+func (tr *assertTranslator) peek() string {
+	if tr.pos < len(tr.tokens) {
+		return tr.tokens[tr.pos]
+	}
+	return ""
+}
+
+// consume the expected token
+func (tr *assertTranslator) expect(token string) error {
+	if tr.peek() != token {
+		return fmt.Errorf("expected %s", token)
+	}
+	tr.pos++
+	return nil
+}
+
+// expr := "if" "(" expr ")" "then" expr "else" expr | and ("or" and)*
+func (tr *assertTranslator) expr() (string, error) {
+	if tr.peek() == "if" {
+		return tr.ifExpr()
+	}
+	return tr.list("or", "anyOf", tr.and)
+}
+
+// and := unary ("and" unary)*
+func (tr *assertTranslator) and() (string, error) {
+	return tr.list("and", "allOf", tr.unary)
+}
+
+// a list of operands joined by an operator, as a combining keyword
+func (tr *assertTranslator) list(op string, keyword string, operand func() (string, error)) (string, error) {
+	first, err := operand()
+	if err != nil || tr.peek() != op {
+		return first, err
+	}
+	operands := []string{"{" + first + "}"}
+	for tr.peek() == op {
+		tr.pos++
+		next, err := operand()
+		if err != nil {
+			return "", err
+		}
+		operands = append(operands, "{"+next+"}")
+	}
+	return fmt.Sprintf("\"%s\": [%s]", keyword, strings.Join(operands, ", ")), nil
+}
+
+// if (c) then a else b
+// draft 7 has if / then / else, earlier drafts need (c and a) or (not c and b)
+func (tr *assertTranslator) ifExpr() (string, error) {
+	tr.pos++
+	if err := tr.expect("("); err != nil {
+		return "", err
+	}
+	cond, err := tr.expr()
+	if err != nil {
+		return "", err
+	}
+	if err = tr.expect(")"); err != nil {
+		return "", err
+	}
+	if err = tr.expect("then"); err != nil {
+		return "", err
+	}
+	then, err := tr.expr()
+	if err != nil {
+		return "", err
+	}
+	if err = tr.expect("else"); err != nil {
+		return "", err
+	}
+	els, err := tr.expr()
+	if err != nil {
+		return "", err
+	}
+	if tr.ctxt.draft >= 7 {
+		return fmt.Sprintf("\"if\": {%s}, \"then\": {%s}, \"else\": {%s}", cond, then, els), nil
+	}
+	return fmt.Sprintf("\"anyOf\": [{\"allOf\": [{%s}, {%s}]}, {\"allOf\": [{\"not\": {%s}}, {%s}]}]", cond, then, cond, els), nil
+}
+
+// unary := "not" "(" expr ")" | "(" expr ")" | function | comparison
+func (tr *assertTranslator) unary() (string, error) {
+	switch tr.peek() {
+	case "(":
+		tr.pos++
+		inner, err := tr.expr()
+		if err != nil {
+			return "", err
+		}
+		return inner, tr.expect(")")
+	case "not", "exists", "empty", "true", "false", "count", "string-length":
+		return tr.function()
+	}
+	return tr.comparison()
+}
+
+// the boolean functions, and the count and string-length comparisons
+func (tr *assertTranslator) function() (string, error) {
+	name := tr.peek()
+	tr.pos++
+	if err := tr.expect("("); err != nil {
+		return "", err
+	}
+	var arg string
+	var err error
+	switch name {
+	case "true", "false":
+	case "not":
+		arg, err = tr.expr()
+	default:
+		arg, err = tr.path()
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = tr.expect(")"); err != nil {
+		return "", err
+	}
+	switch name {
+	case "true":
+		return "", nil
+	case "false":
+		return "\"not\": {}", nil
+	case "not":
+		return fmt.Sprintf("\"not\": {%s}", arg), nil
+	case "exists":
+		return tr.present(arg)
+	case "empty":
+		present, err := tr.present(arg)
+		return fmt.Sprintf("\"not\": {%s}", present), err
+	case "count":
+		return tr.count(arg)
+	default:
+		return tr.stringLength(arg)
+	}
+}
+
+// path := "$value" | "@" name | name
+// names lose any prefix, as properties are named by local name
+func (tr *assertTranslator) path() (string, error) {
+	token := tr.peek()
+	prefix := ""
+	if token == "@" {
+		prefix = "@"
+		tr.pos++
+		token = tr.peek()
+	}
+	if token == "" || strings.ContainsRune("()=<>,'\"!", rune(token[0])) {
+		return "", fmt.Errorf("expected a name")
+	}
+	if tr.pos+1 < len(tr.tokens) && tr.tokens[tr.pos+1] == "(" {
+		return "", fmt.Errorf("unsupported function %s", token)
+	}
+	tr.pos++
+	if token == "$value" {
+		if tr.simple == nil || prefix != "" {
+			return "", fmt.Errorf("$value is only for simple types")
+		}
+		return token, nil
+	}
+	if idx := strings.Index(token, ":"); idx > -1 {
+		token = token[idx+1:]
+	}
+	if prefix == "@" {
+		if _, found := findAttr(tr.attrs(), token); !found {
+			return "", fmt.Errorf("no attribute %s", token)
+		}
+	} else if tr.cmplx == nil {
+		return "", fmt.Errorf("%s is not $value", token)
+	} else if _, found := tr.cmplx.findElem(token); !found {
+		return "", fmt.Errorf("no element %s", token)
+	}
+	return prefix + token, nil
+}
+
+// the attributes of the type
+func (tr *assertTranslator) attrs() []attribute {
+	if tr.cmplx != nil {
+		return tr.cmplx.attrs
+	}
+	return tr.simple.attrs
+}
+
+// constraints on $value, which is the #value of a type with attributes
+func (tr *assertTranslator) valueSchema(constraint string) string {
+	if len(tr.simple.attrs) > 0 || tr.simple.anyAttr != nil {
+		return fmt.Sprintf("\"properties\": {\"#value\": {%s}}", constraint)
+	}
+	return constraint
+}
+
+// the schema of a child being present
+func (tr *assertTranslator) present(path string) (string, error) {
+	if path == "$value" {
+		return "", nil
+	}
+	return fmt.Sprintf("\"required\": [\"%s\"]", path), nil
+}
+
+// does a child element repeat, so is an array?
+func (tr *assertTranslator) repeats(path string) bool {
+	if path == "$value" || strings.HasPrefix(path, "@") {
+		return false
+	}
+	if max, ok := tr.maxes[path]; ok {
+		return max > 1
+	}
+	el, _ := tr.cmplx.findElem(path)
+	return occurs(el.maxOccurs) > 1
+}
+
+// count(path) compared with a number, for a child
+// a single element is present for a count of 1 and absent for 0;
+// a repeating one is an array whose length is constrained
+func (tr *assertTranslator) count(path string) (string, error) {
+	if path == "$value" || strings.HasPrefix(path, "@") {
+		return "", fmt.Errorf("count of %s", path)
+	}
+	op, n, err := tr.numberComparison()
+	if err != nil {
+		return "", err
+	}
+	if op == "!=" || op == "ne" {
+		return fmt.Sprintf("\"not\": {%s}", tr.countSchema(path, "=", n)), nil
+	}
+	return tr.countSchema(path, op, n), nil
+}
+
+// the schema of a count comparison other than !=
+func (tr *assertTranslator) countSchema(path string, op string, n int64) string {
+	min, max := countRange(op, n)
+	switch {
+	case max >= 0 && max < min:
+		return "\"not\": {}"
+	case max == 0:
+		return fmt.Sprintf("\"not\": {\"required\": [\"%s\"]}", path)
+	case max < 0 && min == 0:
+		return ""
+	case !tr.repeats(path):
+		switch {
+		case min > 1:
+			return "\"not\": {}"
+		case min == 1:
+			return fmt.Sprintf("\"required\": [\"%s\"]", path)
+		}
+		return ""
+	}
+	constraints := make([]string, 0)
+	if min > 1 {
+		constraints = append(constraints, fmt.Sprintf("\"minItems\": %d", min))
+	}
+	if max >= 0 {
+		constraints = append(constraints, fmt.Sprintf("\"maxItems\": %d", max))
+	}
+	schema := fmt.Sprintf("\"required\": [\"%s\"]", path)
+	if len(constraints) > 0 {
+		schema += fmt.Sprintf(", \"properties\": {\"%s\": {%s}}", path, strings.Join(constraints, ", "))
+	}
+	if min == 0 { // absent, or present with at most max
+		return fmt.Sprintf("\"anyOf\": [{\"not\": {\"required\": [\"%s\"]}}, {%s}]", path, schema)
+	}
+	return schema
+}
+
+// the range of counts allowed by a comparison with n, -1 for no maximum
+// != is the negation of =
+func countRange(op string, n int64) (int64, int64) {
+	switch op {
+	case "<", "lt":
+		if n == 0 { // never
+			return 1, 0
+		}
+		return 0, n - 1
+	case "<=", "le":
+		return 0, n
+	case ">", "gt":
+		return n + 1, -1
+	case ">=", "ge":
+		return n, -1
+	}
+	return n, n
+}
+
+// string-length($value) compared with a number
+func (tr *assertTranslator) stringLength(path string) (string, error) {
+	if path != "$value" {
+		return "", fmt.Errorf("string-length of %s", path)
+	}
+	op, n, err := tr.numberComparison()
+	if err != nil {
+		return "", err
+	}
+	min, max := countRange(op, n)
+	constraints := make([]string, 0)
+	if min > 0 {
+		constraints = append(constraints, fmt.Sprintf("\"minLength\": %d", min))
+	}
+	if max >= 0 {
+		constraints = append(constraints, fmt.Sprintf("\"maxLength\": %d", max))
+	}
+	schema := tr.valueSchema(strings.Join(constraints, ", "))
+	if op == "!=" || op == "ne" {
+		return fmt.Sprintf("\"not\": {%s}", schema), nil
+	}
+	return schema, nil
+}
+
+// an operator and a whole number
+func (tr *assertTranslator) numberComparison() (string, int64, error) {
+	op := tr.peek()
+	if !isComparison(op) {
+		return "", 0, fmt.Errorf("expected a comparison")
+	}
+	tr.pos++
+	n, err := strconv.ParseInt(tr.peek(), 10, 64)
+	if err != nil || n < 0 {
+		return "", 0, fmt.Errorf("expected a count")
+	}
+	tr.pos++
+	return op, n, nil
+}
+
+// is this a general or value comparison operator?
+func isComparison(op string) bool {
+	switch op {
+	case "=", "!=", "<", "<=", ">", ">=", "eq", "ne", "lt", "le", "gt", "ge":
+		return true
+	}
+	return false
+}
+
+// comparison := path [op literal]
+// a path alone tests that the child is present; a comparison is only
+// true if it is present and its value compares as given
+func (tr *assertTranslator) comparison() (string, error) {
+	path, err := tr.path()
+	if err != nil {
+		return "", err
+	}
+	op := tr.peek()
+	if !isComparison(op) {
+		return tr.present(path)
+	}
+	tr.pos++
+	literal := tr.peek()
+	tr.pos++
+	if tr.repeats(path) {
+		return "", fmt.Errorf("comparison with repeating %s", path)
+	}
+	value, err := tr.literal(literal, path)
+	if err != nil {
+		return "", err
+	}
+	var constraint string
+	switch op {
+	case "=", "eq":
+		constraint = fmt.Sprintf("\"enum\": [%s]", value)
+	case "!=", "ne":
+		constraint = fmt.Sprintf("\"not\": {\"enum\": [%s]}", value)
+	default:
+		if !jsonNumber.MatchString(value) {
+			return "", fmt.Errorf("%s compared with %s", op, literal)
+		}
+		constraint = tr.numberLimit(op, value)
+	}
+	if path == "$value" {
+		return tr.valueSchema(constraint), nil
+	}
+	// the value of a type with attributes is its #value
+	if qn, ok := tr.childType(path); ok && hasAttrs(qn, tr.ctxt) {
+		constraint = fmt.Sprintf("\"properties\": {\"#value\": {%s}}", constraint)
+	}
+	return fmt.Sprintf("\"required\": [\"%s\"], \"properties\": {\"%s\": {%s}}", path, path, constraint), nil
+}
+
+// the qualified type of a child
+func (tr *assertTranslator) childType(path string) (qn xml.Name, ok bool) {
+	if strings.HasPrefix(path, "@") {
+		attr, found := findAttr(tr.attrs(), path[1:])
+		return attr.atype, found
+	}
+	el, found := tr.cmplx.findElem(path)
+	return el.etype, found
+}
+
+// a literal as JSON, typed as the value it is compared with
+func (tr *assertTranslator) literal(literal string, path string) (string, error) {
+	if literal == "" {
+		return "", fmt.Errorf("expected a value")
+	}
+	text := literal
+	quoted := literal[0] == '\'' || literal[0] == '"'
+	if quoted {
+		text = literal[1 : len(literal)-1]
+	} else if !jsonNumber.MatchString(literal) {
+		return "", fmt.Errorf("%s is not a literal", literal)
+	}
+	if path == "$value" {
+		return jsonLiteral(text, tr.simple.name, tr.ctxt), nil
+	}
+	if qn, ok := tr.childType(path); ok {
+		return jsonLiteral(text, qn, tr.ctxt), nil
+	}
+	return jsonLiteral(text, xml.Name{}, tr.ctxt), nil
+}
+
+// a minimum or maximum, in the form of the draft being written
+func (tr *assertTranslator) numberLimit(op string, value string) string {
+	keyword, exclusiveKw, exclusive := "maximum", "exclusiveMaximum", false
+	switch op {
+	case ">", "gt":
+		keyword, exclusiveKw, exclusive = "minimum", "exclusiveMinimum", true
+	case ">=", "ge":
+		keyword, exclusiveKw = "minimum", "exclusiveMinimum"
+	case "<", "lt":
+		exclusive = true
+	}
+	switch {
+	case !exclusive:
+		return fmt.Sprintf("\"%s\": %s", keyword, value)
+	case tr.ctxt.draft < 6:
+		return fmt.Sprintf("\"%s\": %s, \"%s\": true", keyword, value, exclusiveKw)
+	}
+	return fmt.Sprintf("\"%s\": %s", exclusiveKw, value)
+}
+
+// the schemas of the translated assertions, and the tests of the others
+func splitAssertions(asserts []assertion) ([]string, []string) {
+	schemas, tests := make([]string, 0), make([]string, 0)
+	for _, a := range asserts {
+		if a.schema != "" {
+			schemas = append(schemas, a.schema)
+		} else {
+			tests = append(tests, jsonEscape(a.test))
+		}
+	}
+	return schemas, tests
+}
+
+// write the tests of assertions that couldn't be translated
+func writeUntranslated(tests []string, f io.Writer, ctxt *context, indent int) {
+	if len(tests) > 0 {
+		inPrintf(f, indent, "\"x-assert\": %s,\n", arrayString(tests))
+	}
+}
@@ -0,0 +1,118 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// assertion tests
+// the tests of xs:assert and xs:assertion, translated where they can be
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// the type the complex type tests refer to
+const assertedType = `<xs:complexType name="T"><xs:sequence>
+		<xs:element name="A" type="xs:string" minOccurs="0"/>
+		<xs:element name="B" type="xs:int" minOccurs="0" maxOccurs="5"/></xs:sequence>
+		<xs:attribute name="Ccy" type="xs:string"/>%s</xs:complexType>`
+
+// a translated test becomes a schema in the type's allOf, others are
+// kept in x-assert with a warning
+func TestAssertions(t *testing.T) {
+	tests := []struct {
+		name   string
+		simple bool   // the test of a string simple type, rather than of T
+		test   string // escaped for XML
+		schema string // the translation, "" if not translated
+		warn   string
+	}{
+		{"exists", false, `exists(A) or exists(@Ccy)`,
+			`{"anyOf": [{"required": ["A"]}, {"required": ["@Ccy"]}]}`, ""},
+		{"empty", false, `empty(@Ccy)`,
+			`{"not": {"required": ["@Ccy"]}}`, ""},
+		{"count", false, `count(B) &lt;= 2`,
+			`{"anyOf": [{"not": {"required": ["B"]}}, {"required": ["B"], "properties": {"B": {"maxItems": 2}}}]}`, ""},
+		{"string-length", true, `string-length($value) &lt;= 4`,
+			`{"maxLength": 4}`, ""},
+		{"comparison", true, `$value != 'NONE'`,
+			`{"not": {"enum": ["NONE"]}}`, ""},
+		{"unknown element", false, `exists(C)`, "", "not translated: no element C"},
+		{"path", false, `A/B = 1`, "", "not translated: unsupported /"},
+		{"function", false, `foo(A)`, "", "not translated: unsupported function foo"},
+		{"value of a complex type", false, `$value = 1`, "", "not translated: $value is only for simple types"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			components := strings.Replace(assertedType, "%s", `<xs:assert test="`+tt.test+`"/>`, 1) +
+				`<xs:element name="Doc" type="T"/>`
+			def := "T"
+			if tt.simple {
+				components = `<xs:simpleType name="S"><xs:restriction base="xs:string"><xs:assertion test="` +
+					tt.test + `"/></xs:restriction></xs:simpleType><xs:element name="Doc" type="S"/>`
+				def = "S"
+			}
+			out, diags := convert(t, testSchema(components))
+			want := []string{}
+			if tt.warn != "" {
+				want = append(want, tt.warn)
+			}
+			checkDiags(t, diags, want)
+			d := lookup(decode(t, out), "definitions", def)
+			if tt.schema == "" {
+				if untranslated, _ := lookup(d, "x-assert").([]interface{}); len(untranslated) != 1 {
+					t.Errorf("test not kept in x-assert: %v", d)
+				}
+				return
+			}
+			var schema interface{}
+			if err := json.Unmarshal([]byte(tt.schema), &schema); err != nil {
+				t.Fatal(err)
+			}
+			if !hasSchema(d, schema) {
+				t.Errorf("no %s in %v", tt.schema, d)
+			}
+			if lookup(d, "x-assert") != nil {
+				t.Errorf("translated test kept in x-assert: %v", d)
+			}
+		})
+	}
+}
+
+// is a schema one of a definition's allOf, or, when it is the only
+// one, merged into the definition?
+func hasSchema(d interface{}, schema interface{}) bool {
+	all, _ := lookup(d, "allOf").([]interface{})
+	for _, s := range all {
+		if equalJson(s, schema) {
+			return true
+		}
+	}
+	for key, value := range schema.(map[string]interface{}) {
+		if !equalJson(lookup(d, key), value) {
+			return false
+		}
+	}
+	return true
+}
+
+// are two decoded JSON values the same?
+func equalJson(a interface{}, b interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,200 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// bounds
+// exact minInclusive / maxInclusive / minExclusive / maxExclusive facets,
+// and the bounds implied by totalDigits and fractionDigits
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+)
+
+// a min or max facet
+// value is nil for bounds that aren't numbers, such as dates
+type bound struct {
+	set   bool
+	value *big.Rat
+	text  string // the number as written in JSON, or the XSD value
+}
+
+// parse the value of a min or max facet, keeping it exact
+func parseBound(text string) bound {
+	text = strings.TrimSpace(text)
+	b := bound{set: true, text: text}
+	if r, ok := new(big.Rat).SetString(text); ok {
+		b.value = r
+		b.text = numberText(text, r)
+	}
+	return b
+}
+
+// a decimal as a valid JSON number, with the digits as written
+// e.g. "+.50" becomes "0.50"
+func numberText(text string, r *big.Rat) string {
+	if jsonNumber.MatchString(text) {
+		return text
+	}
+	if strings.ContainsAny(text, "eE") {
+		f, _ := r.Float64()
+		return big.NewFloat(f).Text('g', -1)
+	}
+	decimals := 0
+	if idx := strings.Index(text, "."); idx > -1 {
+		decimals = len(text) - idx - 1
+	}
+	return r.FloatString(decimals)
+}
+
+// a bound that may be exclusive
+type limit struct {
+	b         bound
+	exclusive bool
+}
+
+// the tightest of the numeric limits; lower selects a minimum rather
+// than a maximum. An exclusive limit beats an equal inclusive one.
+func tightest(limits []limit, lower bool) (limit, bool) {
+	best, found := limit{}, false
+	for _, l := range limits {
+		if !l.b.set || l.b.value == nil {
+			continue
+		}
+		if !found {
+			best, found = l, true
+			continue
+		}
+		cmp := l.b.value.Cmp(best.b.value)
+		if !lower {
+			cmp = -cmp
+		}
+		if cmp > 0 || (cmp == 0 && l.exclusive) {
+			best = l
+		}
+	}
+	return best, found
+}
+
+// the largest magnitude totalDigits and fractionDigits allow, if limited:
+// (10^totalDigits - 1) / 10^fractionDigits, or just under 10^totalDigits
+// when the fraction digits are not limited
+func digitsLimit(simple simpleType) (limit, bool) {
+	if simple.totalDigits < 0 {
+		return limit{}, false
+	}
+	ten := big.NewInt(10)
+	pow := new(big.Int).Exp(ten, big.NewInt(simple.totalDigits), nil)
+	if simple.fractionDigits < 0 || simple.fractionDigits > simple.totalDigits {
+		r := new(big.Rat).SetInt(pow)
+		return limit{bound{set: true, value: r, text: r.FloatString(0)}, true}, true
+	}
+	r := new(big.Rat).SetFrac(new(big.Int).Sub(pow, big.NewInt(1)), new(big.Int).Exp(ten, big.NewInt(simple.fractionDigits), nil))
+	return limit{bound{set: true, value: r, text: r.FloatString(int(simple.fractionDigits))}, false}, true
+}
+
+// write the bounds of a simple type, including those of totalDigits
+// draft 4 marks an exclusive bound with a boolean beside minimum / maximum,
+// later drafts give exclusiveMinimum / exclusiveMaximum the value itself
+// bounds that aren't numbers are only noted, see boundsNotes
+func writeBounds(simple simpleType, f io.Writer, ctxt *context, indent int) {
+	lowers := []limit{{simple.minInclusive, false}, {simple.minExclusive, true}}
+	uppers := []limit{{simple.maxInclusive, false}, {simple.maxExclusive, true}}
+	if digits, ok := digitsLimit(simple); ok {
+		uppers = append(uppers, digits)
+		neg := new(big.Rat).Neg(digits.b.value)
+		lowers = append(lowers, limit{bound{set: true, value: neg, text: "-" + digits.b.text}, digits.exclusive})
+	}
+	if l, ok := tightest(lowers, true); ok {
+		writeLimit(l, "minimum", "exclusiveMinimum", f, ctxt, indent)
+	}
+	if l, ok := tightest(uppers, false); ok {
+		writeLimit(l, "maximum", "exclusiveMaximum", f, ctxt, indent)
+	}
+}
+
+// write a minimum or maximum
+func writeLimit(l limit, keyword string, exclusiveKw string, f io.Writer, ctxt *context, indent int) {
+	switch {
+	case !l.exclusive:
+		inPrintf(f, indent, "\"%s\": %s,\n", keyword, l.b.text)
+	case ctxt.draft < 6:
+		inPrintf(f, indent, "\"%s\": %s,\n", keyword, l.b.text)
+		inPrintf(f, indent, "\"%s\": true,\n", exclusiveKw)
+	default:
+		inPrintf(f, indent, "\"%s\": %s,\n", exclusiveKw, l.b.text)
+	}
+}
+
+// fractionDigits as a multipleOf, e.g. 0.01 for 2
+func writeMultipleOf(simple simpleType, f io.Writer, ctxt *context, indent int) {
+	if simple.fractionDigits < 0 {
+		return
+	}
+	r := new(big.Rat).SetFrac(big.NewInt(1), new(big.Int).Exp(big.NewInt(10), big.NewInt(simple.fractionDigits), nil))
+	inPrintf(f, indent, "\"multipleOf\": %s,\n", r.FloatString(int(simple.fractionDigits)))
+}
+
+// with -digits string, numbers limited by totalDigits or fractionDigits
+// are written as strings, so that no precision is lost
+func digitsAsString(simple simpleType, ctxt *context) bool {
+	if !ctxt.digitsString || (simple.totalDigits < 0 && simple.fractionDigits < 0) || !isBuiltin(simple.base) {
+		return false
+	}
+	jtype, _ := mapTypename(simple.base)
+	return jtype == "number"
+}
+
+// a pattern for a decimal string within totalDigits and fractionDigits
+// the digit count includes any leading or trailing zeros
+func digitsPattern(simple simpleType) string {
+	fraction := `(?:\.\d+)?`
+	switch {
+	case simple.fractionDigits == 0:
+		fraction = ""
+	case simple.fractionDigits > 0:
+		fraction = fmt.Sprintf(`(?:\.\d{1,%d})?`, simple.fractionDigits)
+	}
+	total := ""
+	if simple.totalDigits > 0 {
+		total = fmt.Sprintf(`(?=(?:\d\.?){1,%d}$)`, simple.totalDigits)
+	}
+	return `[+-]?` + total + `\d+` + fraction
+}
+
+// the bounds JSON schema can't check, for the $comment: all of them for a
+// number written as a string, otherwise those that aren't numbers, e.g.
+// of a date
+func boundsNotes(simple simpleType, asString bool) []string {
+	notes := make([]string, 0)
+	for _, facet := range []struct {
+		name string
+		b    bound
+	}{
+		{"minInclusive", simple.minInclusive},
+		{"minExclusive", simple.minExclusive},
+		{"maxInclusive", simple.maxInclusive},
+		{"maxExclusive", simple.maxExclusive},
+	} {
+		if facet.b.set && (asString || facet.b.value == nil) {
+			notes = append(notes, "XML specified "+facet.name+"="+facet.b.text)
+		}
+	}
+	return notes
+}
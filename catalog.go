@@ -0,0 +1,197 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// catalog
+// OASIS XML catalog support, used to remap schemaLocations offline
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// a prefix rewrite rule (rewriteSystem / rewriteURI)
+type rewriteRule struct {
+	start  string
+	prefix string
+}
+
+// a suffix rule (systemSuffix / uriSuffix)
+type suffixRule struct {
+	suffix string
+	uri    string
+}
+
+// the entries of a catalog file and any it chains to
+type catalog struct {
+	system  map[string]string // systemId -> uri
+	uri     map[string]string // name (e.g. namespace) -> uri
+	rewrite []rewriteRule
+	suffix  []suffixRule
+	next    []*catalog // nextCatalog entries
+}
+
+// create an empty catalog
+func newCatalog() *catalog {
+	return &catalog{
+		system:  make(map[string]string),
+		uri:     make(map[string]string),
+		rewrite: make([]rewriteRule, 0),
+		suffix:  make([]suffixRule, 0),
+		next:    make([]*catalog, 0),
+	}
+}
+
+// load a catalog file
+// relative uris are resolved against the catalog file (or xml:base)
+// entries that can't be used are reported and ignored
+func loadCatalog(fname string, ctxt *context) (*catalog, error) {
+	return loadCatalogChain(fname, make(map[string]bool), ctxt)
+}
+
+// load a catalog, following nextCatalog but never the same file twice
+func loadCatalogChain(fname string, seen map[string]bool, ctxt *context) (*catalog, error) {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return nil, err
+	}
+	cat := newCatalog()
+	if seen[abs] {
+		return cat, nil
+	}
+	seen[abs] = true
+
+	f, err := os.Open(abs)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bases := []string{filepath.Dir(abs)}
+	decoder := xml.NewDecoder(f)
+	for {
+		t, err := decoder.Token()
+		if t == nil {
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("catalog %s: %v", fname, err)
+			}
+			break
+		}
+		switch el := t.(type) {
+		case xml.StartElement:
+			attrs := make(map[string]string)
+			base := bases[len(bases)-1]
+			for _, attr := range el.Attr {
+				if attr.Name.Local == "base" && attr.Name.Space == "http://www.w3.org/XML/1998/namespace" {
+					base = catalogResolve(base, attr.Value)
+				}
+				attrs[attr.Name.Local] = attr.Value
+			}
+			bases = append(bases, base)
+			switch el.Name.Local {
+			case "system":
+				cat.system[attrs["systemId"]] = catalogResolve(base, attrs["uri"])
+			case "uri":
+				cat.uri[attrs["name"]] = catalogResolve(base, attrs["uri"])
+			case "rewriteSystem":
+				cat.rewrite = append(cat.rewrite, rewriteRule{attrs["systemIdStartString"], catalogResolve(base, attrs["rewritePrefix"])})
+			case "rewriteURI":
+				cat.rewrite = append(cat.rewrite, rewriteRule{attrs["uriStartString"], catalogResolve(base, attrs["rewritePrefix"])})
+			case "systemSuffix":
+				cat.suffix = append(cat.suffix, suffixRule{attrs["systemIdSuffix"], catalogResolve(base, attrs["uri"])})
+			case "uriSuffix":
+				cat.suffix = append(cat.suffix, suffixRule{attrs["uriSuffix"], catalogResolve(base, attrs["uri"])})
+			case "nextCatalog":
+				next, err := loadCatalogChain(catalogResolve(base, attrs["catalog"]), seen, ctxt)
+				if err != nil {
+					line, column := decoder.InputPos()
+					diagnoseAt(ctxt, sevWarning, abs, line, column, "nextCatalog %s ignored: %v", attrs["catalog"], err)
+				} else {
+					cat.next = append(cat.next, next)
+				}
+			case "catalog", "group": // containers
+			default:
+				line, column := decoder.InputPos()
+				diagnoseAt(ctxt, sevWarning, abs, line, column, "catalog entry %s ignored", el.Name.Local)
+			}
+		case xml.EndElement:
+			bases = bases[:len(bases)-1]
+		}
+	}
+	return cat, nil
+}
+
+// resolve a catalog uri against the current base directory
+func catalogResolve(base string, uri string) string {
+	uri = strings.TrimPrefix(uri, "file://")
+	if isRemote(uri) || filepath.IsAbs(uri) {
+		return uri
+	}
+	resolved := filepath.Join(base, uri)
+	if strings.HasSuffix(uri, "/") { // keep it usable as a rewrite prefix
+		resolved += string(filepath.Separator)
+	}
+	return resolved
+}
+
+// look up an identifier (schemaLocation or namespace) in the catalog
+// exact matches win, then the longest rewrite prefix, then the longest suffix
+func (cat *catalog) lookup(id string) (string, bool) {
+	if cat == nil || id == "" {
+		return "", false
+	}
+	if uri, ok := cat.system[id]; ok {
+		return uri, true
+	}
+	if uri, ok := cat.uri[id]; ok {
+		return uri, true
+	}
+	best := -1
+	for i, rule := range cat.rewrite {
+		if strings.HasPrefix(id, rule.start) && (best < 0 || len(rule.start) > len(cat.rewrite[best].start)) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		rule := cat.rewrite[best]
+		return rule.prefix + id[len(rule.start):], true
+	}
+	best = -1
+	for i, rule := range cat.suffix {
+		if strings.HasSuffix(id, rule.suffix) && (best < 0 || len(rule.suffix) > len(cat.suffix[best].suffix)) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		return cat.suffix[best].uri, true
+	}
+	for _, next := range cat.next {
+		if uri, ok := next.lookup(id); ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+// is this location something we can't open locally?
+func isRemote(loc string) bool {
+	return strings.Contains(loc, "://") && !strings.HasPrefix(loc, "file://")
+}
@@ -0,0 +1,147 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// catalog tests
+// catalogs with each kind of entry, and schemas found through them
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// write files into a directory
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		fname := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// a catalog using every kind of entry, and chaining to another
+const testCatalog = `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+	<system systemId="http://example.com/a.xsd" uri="local/a.xsd"/>
+	<uri name="urn:example:b" uri="local/b.xsd"/>
+	<rewriteSystem systemIdStartString="http://example.com/" rewritePrefix="mirror/"/>
+	<rewriteURI uriStartString="http://example.com/deep/" rewritePrefix="deep/"/>
+	<systemSuffix systemIdSuffix="/c.xsd" uri="local/c.xsd"/>
+	<uriSuffix uriSuffix="/long/c.xsd" uri="local/long-c.xsd"/>
+	<group xml:base="based/"><system systemId="http://example.com/d.xsd" uri="d.xsd"/></group>
+	<delegateSystem systemIdStartString="http://example.org/" catalog="other.xml"/>
+	<nextCatalog catalog="next.xml"/>
+	<nextCatalog catalog="missing.xml"/>
+</catalog>`
+
+// the catalog the first chains to, which chains back
+const testNextCatalog = `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">
+	<system systemId="urn:next" uri="next/e.xsd"/>
+	<nextCatalog catalog="catalog.xml"/>
+</catalog>`
+
+// exact entries win, then the longest rewrite, then the longest suffix,
+// then those of the catalogs chained to
+func TestCatalogLookup(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"catalog.xml": testCatalog, "next.xml": testNextCatalog})
+	ctxt := newContext()
+	cat, err := loadCatalog(filepath.Join(dir, "catalog.xml"), &ctxt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkDiags(t, ctxt.diags, []string{"catalog entry delegateSystem ignored", "nextCatalog missing.xml ignored"})
+	tests := []struct {
+		id    string
+		uri   string // relative to the catalog, "" if not found
+		found bool
+	}{
+		{"http://example.com/a.xsd", "local/a.xsd", true},
+		{"urn:example:b", "local/b.xsd", true},
+		{"http://example.com/x/y.xsd", "mirror/x/y.xsd", true},
+		{"http://example.com/deep/y.xsd", "deep/y.xsd", true},
+		{"urn:other/c.xsd", "local/c.xsd", true},
+		{"urn:other/long/c.xsd", "local/long-c.xsd", true},
+		{"http://example.com/d.xsd", "based/d.xsd", true},
+		{"urn:next", "next/e.xsd", true},
+		{"urn:unknown", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			uri, found := cat.lookup(tt.id)
+			want := ""
+			if tt.found {
+				want = filepath.Join(dir, tt.uri)
+			}
+			if found != tt.found || uri != want {
+				t.Errorf("got %q %v, want %q %v", uri, found, want, tt.found)
+			}
+		})
+	}
+}
+
+// an import of a remote schema is read from the local file the catalog
+// maps it to, by location or else by namespace
+func TestCatalogImport(t *testing.T) {
+	common := `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" targetNamespace="urn:common">
+		<xs:simpleType name="Code"><xs:restriction base="xs:string"><xs:maxLength value="4"/></xs:restriction></xs:simpleType>
+		</xs:schema>`
+	tests := []struct {
+		name     string
+		location string // of the import
+		entry    string // of the catalog
+		want     []string
+	}{
+		{"by location", `schemaLocation="http://example.com/common.xsd"`,
+			`<system systemId="http://example.com/common.xsd" uri="common.xsd"/>`, nil},
+		{"by namespace", `schemaLocation="http://example.com/elsewhere.xsd"`,
+			`<uri name="urn:common" uri="common.xsd"/>`, nil},
+		{"without location", ``,
+			`<uri name="urn:common" uri="common.xsd"/>`, nil},
+		{"not in catalog", `schemaLocation="http://example.com/common.xsd"`,
+			``, []string{"remote schema http://example.com/common.xsd not fetched", "type Code not found"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{
+				"common.xsd":  common,
+				"catalog.xml": `<catalog xmlns="urn:oasis:names:tc:entity:xmlns:xml:catalog">` + tt.entry + `</catalog>`,
+			})
+			xsd := `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema" xmlns:c="urn:common">
+				<xs:import namespace="urn:common" ` + tt.location + `/>
+				<xs:element name="Doc" type="c:Code"/></xs:schema>`
+			out, diags := convertWith(t, xsd, func(ctxt *context) {
+				cat, err := loadCatalog(filepath.Join(dir, "catalog.xml"), ctxt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ctxt.catalog = cat
+			})
+			checkDiags(t, diags, tt.want)
+			if tt.want == nil && lookup(decode(t, out), "definitions", "Code", "maxLength") != 4.0 {
+				t.Errorf("imported type not written:\n%s", out)
+			}
+		})
+	}
+}
@@ -24,17 +24,30 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func cmdLineParse(ctxt *context) {
 	inFilePtr := flag.String("in", "", "input file name")
 	outFilePtr := flag.String("out", "", "output file name")
 	domainPtr := flag.String("dom", "", "domain name for $id")
+	catPtr := flag.String("cat", "", "XML catalog file for resolving schemaLocations")
+	anonPtr := flag.String("anon", "name", "anonymous types: name (as definitions) | inline")
+	listPtr := flag.String("list", "string", "xs:list types: string (whitespace separated) | array")
+	digitsPtr := flag.String("digits", "number", "numbers with totalDigits / fractionDigits: number | string")
+	draftPtr := flag.Int("draft", 4, "JSON schema draft: 4 | 6 | 7")
+	rootPtr := flag.String("root", "", "root elements: Name[,Name...] | * (all global elements); default the last in the main file")
+	mixedPtr := flag.String("mixed", "text", "mixed content: text (a #text array) | ordered (a #mixed array of segments)")
 
 	flag.Parse()
 
-	if *inFilePtr == "" || *outFilePtr == "" {
-		fmt.Printf("Usage: %s -in xsdfile -out jsonfile [-dom domain]", filepath.Base(os.Args[0]))
+	if *inFilePtr == "" || *outFilePtr == "" ||
+		(*anonPtr != "name" && *anonPtr != "inline") ||
+		(*listPtr != "string" && *listPtr != "array") ||
+		(*mixedPtr != "text" && *mixedPtr != "ordered") ||
+		(*draftPtr != 4 && *draftPtr != 6 && *draftPtr != 7) ||
+		(*digitsPtr != "number" && *digitsPtr != "string") {
+		fmt.Printf("Usage: %s -in xsdfile -out jsonfile [-dom domain] [-cat catalogfile] [-anon name|inline] [-root name,...|*] [-list string|array] [-mixed text|ordered] [-draft 4|6|7] [-digits number|string]", filepath.Base(os.Args[0]))
 		os.Exit(1)
 	}
 
@@ -43,4 +56,13 @@ func cmdLineParse(ctxt *context) {
 	ctxt.outFile = *outFilePtr
 	ctxt.outFileBase = filepath.Base(ctxt.outFile)
 	ctxt.domain = *domainPtr
+	ctxt.catFile = *catPtr
+	ctxt.anonInline = *anonPtr == "inline"
+	ctxt.listArrays = *listPtr == "array"
+	ctxt.mixedOrdered = *mixedPtr == "ordered"
+	ctxt.draft = *draftPtr
+	ctxt.digitsString = *digitsPtr == "string"
+	if *rootPtr != "" {
+		ctxt.rootNames = strings.Split(*rootPtr, ",")
+	}
 }
@@ -0,0 +1,245 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// cycles
+// find the types and global elements that contain themselves, directly
+// or through others, so that writers refer to them rather than expand them
+
+package main
+
+import (
+	"encoding/xml"
+	"sort"
+	"strings"
+)
+
+// a type or global element in the graph of what contains what
+type typeNode struct {
+	element bool // a global element, rather than a type
+	name    xml.Name
+}
+
+// the recursion found in the schema: every node on a cycle
+// a writer that refers to these rather than expanding them never loops
+type cycleInfo struct {
+	recursive map[typeNode]bool
+}
+
+// the state of Tarjan's strongly connected components algorithm
+type cycleFinder struct {
+	ctxt    *context
+	index   map[typeNode]int
+	low     map[typeNode]int
+	onStack map[typeNode]bool
+	stack   []typeNode
+	info    cycleInfo
+}
+
+// find the cycles, once everything is resolved
+// a cycle of simple types alone, or of derivations alone, is an error,
+// as a type can't be derived from itself
+func analyseCycles(ctxt *context) {
+	cf := cycleFinder{
+		ctxt:    ctxt,
+		index:   make(map[typeNode]int),
+		low:     make(map[typeNode]int),
+		onStack: make(map[typeNode]bool),
+		info: cycleInfo{
+			recursive: make(map[typeNode]bool),
+		},
+	}
+	for _, n := range allNodes(ctxt) {
+		if _, visited := cf.index[n]; !visited {
+			cf.connect(n)
+		}
+	}
+	ctxt.cycles = cf.info
+	checkDerivationCycles(ctxt)
+}
+
+// every type and global element, in a stable order
+func allNodes(ctxt *context) []typeNode {
+	types := make([]xml.Name, 0, len(ctxt.simpleTypes)+len(ctxt.complexTypes))
+	for qn := range ctxt.simpleTypes {
+		types = append(types, qn)
+	}
+	for qn := range ctxt.complexTypes {
+		types = append(types, qn)
+	}
+	elems := make([]xml.Name, 0, len(ctxt.elements))
+	for qn := range ctxt.elements {
+		elems = append(elems, qn)
+	}
+	sortNames(types)
+	sortNames(elems)
+	nodes := make([]typeNode, 0, len(types)+len(elems))
+	for _, qn := range types {
+		nodes = append(nodes, typeNode{name: qn})
+	}
+	for _, qn := range elems {
+		nodes = append(nodes, typeNode{element: true, name: qn})
+	}
+	return nodes
+}
+
+// the types and global elements a node refers to
+// builtins and missing types are left out, as they can't recurse
+func (cf *cycleFinder) edges(n typeNode) []typeNode {
+	ctxt := cf.ctxt
+	names := make([]xml.Name, 0)
+	elems := make([]xml.Name, 0)
+	var attrs []attribute
+	if n.element {
+		if el, ok := ctxt.elements[n.name]; ok {
+			names = append(names, el.etype)
+			elems = append(elems, substMembers(n.name, ctxt)...)
+		}
+	} else if simple, ok := ctxt.simpleTypes[n.name]; ok {
+		names = append(names, simple.base, simple.itemType)
+		names = append(names, simple.memberTypes...)
+		attrs = simple.attrs
+	} else if cmplx, ok := ctxt.complexTypes[n.name]; ok {
+		if cmplx.derivation != "" && cmplx.base != cmplx.name {
+			names = append(names, cmplx.base)
+		}
+		for _, el := range cmplx.elems {
+			if el.ref.Local != "" {
+				elems = append(elems, el.ref)
+			} else {
+				names = append(names, el.etype)
+			}
+		}
+		attrs = cmplx.attrs
+	}
+	for _, attr := range attrs {
+		names = append(names, attr.atype)
+	}
+	to := make([]typeNode, 0, len(names)+len(elems))
+	for _, qn := range names {
+		_, isSimple := ctxt.simpleTypes[qn]
+		_, isComplex := ctxt.complexTypes[qn]
+		if isSimple || isComplex {
+			to = append(to, typeNode{name: qn})
+		}
+	}
+	for _, qn := range elems {
+		if _, ok := ctxt.elements[qn]; ok {
+			to = append(to, typeNode{element: true, name: qn})
+		}
+	}
+	return to
+}
+
+// visit a node and everything reachable from it, recording each
+// strongly connected component as it is completed
+func (cf *cycleFinder) connect(n typeNode) {
+	cf.index[n] = len(cf.index)
+	cf.low[n] = cf.index[n]
+	cf.stack = append(cf.stack, n)
+	cf.onStack[n] = true
+	selfLoop := false
+	for _, to := range cf.edges(n) {
+		if _, visited := cf.index[to]; !visited {
+			cf.connect(to)
+			if cf.low[to] < cf.low[n] {
+				cf.low[n] = cf.low[to]
+			}
+		} else if cf.onStack[to] {
+			// back to a node being visited, so this edge closes a cycle
+			selfLoop = selfLoop || to == n
+			if cf.index[to] < cf.low[n] {
+				cf.low[n] = cf.index[to]
+			}
+		}
+	}
+	if cf.low[n] != cf.index[n] {
+		return
+	}
+	component := make([]typeNode, 0)
+	for {
+		top := cf.stack[len(cf.stack)-1]
+		cf.stack = cf.stack[:len(cf.stack)-1]
+		cf.onStack[top] = false
+		component = append(component, top)
+		if top == n {
+			break
+		}
+	}
+	if len(component) == 1 && !selfLoop {
+		return
+	}
+	simpleOnly := true
+	for _, c := range component {
+		cf.info.recursive[c] = true
+		if _, isSimple := cf.ctxt.simpleTypes[c.name]; c.element || !isSimple {
+			simpleOnly = false
+		}
+	}
+	if simpleOnly {
+		diagnoseComponent(cf.ctxt, sevError, n, "circular simple type definition: %s", nodeNames(component))
+	}
+}
+
+// a complex type can't be derived from itself, directly or indirectly
+// a redefinition's base is the original, so is not followed
+func checkDerivationCycles(ctxt *context) {
+	reported := make(map[xml.Name]bool)
+	names := make([]xml.Name, 0, len(ctxt.complexTypes))
+	for qn := range ctxt.complexTypes {
+		names = append(names, qn)
+	}
+	sortNames(names)
+	for _, start := range names {
+		seen := map[xml.Name]bool{start: true}
+		chain := []typeNode{{name: start}}
+		for qn := start; ; {
+			cmplx, ok := ctxt.complexTypes[qn]
+			if !ok || cmplx.derivation == "" || cmplx.base == cmplx.name {
+				break
+			}
+			qn = cmplx.base
+			if qn == start && !reported[start] {
+				for _, n := range chain {
+					reported[n.name] = true
+				}
+				diagnoseComponent(ctxt, sevError, typeNode{name: start}, "circular derivation: %s", nodeNames(chain))
+			}
+			if seen[qn] {
+				break
+			}
+			seen[qn] = true
+			chain = append(chain, typeNode{name: qn})
+		}
+	}
+}
+
+// the names of nodes, for a diagnostic
+func nodeNames(nodes []typeNode) string {
+	names := make([]string, len(nodes))
+	for i, n := range nodes {
+		names[i] = n.name.Local
+		if n.element {
+			names[i] = "element " + names[i]
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// is a type on a cycle?
+func isRecursiveType(qn xml.Name, ctxt *context) bool {
+	return ctxt.cycles.recursive[typeNode{name: qn}]
+}
@@ -0,0 +1,77 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// cycle tests
+// recursive types, and the cycles a type can't be on
+
+package main
+
+import (
+	"testing"
+)
+
+// a recursive type is found however long its cycle is, and is kept as a
+// definition when anonymous types are written inline; a type can't be
+// derived from itself
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name       string
+		components string
+		recursive  []string // the definitions kept, though anonymous
+		inlined    []string // the anonymous types written inline
+		want       []string
+	}{
+		{"no cycle", `<xs:element name="Doc"><xs:complexType><xs:sequence>
+				<xs:element name="Leaf"><xs:complexType><xs:sequence><xs:element name="V" type="xs:string"/></xs:sequence></xs:complexType></xs:element>
+				</xs:sequence></xs:complexType></xs:element>`,
+			nil, []string{"Doc_Leaf"}, nil},
+		{"through a global element", `<xs:element name="Doc"><xs:complexType><xs:sequence>
+				<xs:element name="Node"><xs:complexType><xs:sequence><xs:element ref="Doc" minOccurs="0"/></xs:sequence></xs:complexType></xs:element>
+				<xs:element name="Leaf"><xs:complexType><xs:sequence><xs:element name="V" type="xs:string"/></xs:sequence></xs:complexType></xs:element>
+				</xs:sequence></xs:complexType></xs:element>`,
+			[]string{"Doc", "Doc_Node"}, []string{"Doc_Leaf"}, nil},
+		{"through named types", `<xs:complexType name="A"><xs:sequence><xs:element name="B" minOccurs="0">
+				<xs:complexType><xs:sequence><xs:element name="A" type="A" minOccurs="0"/></xs:sequence></xs:complexType>
+				</xs:element></xs:sequence></xs:complexType><xs:element name="Doc" type="A"/>`,
+			[]string{"A_B"}, nil, nil},
+		{"simple types", `<xs:simpleType name="S1"><xs:restriction base="S2"/></xs:simpleType>
+				<xs:simpleType name="S2"><xs:restriction base="S1"/></xs:simpleType><xs:element name="Doc" type="S1"/>`,
+			nil, nil, []string{"circular simple type definition: S1, S2"}},
+		{"derivation", `<xs:complexType name="C1"><xs:complexContent><xs:extension base="C2"/></xs:complexContent></xs:complexType>
+				<xs:complexType name="C2"><xs:complexContent><xs:extension base="C1"/></xs:complexContent></xs:complexType>
+				<xs:element name="Doc" type="C1"/>`,
+			nil, nil, []string{"circular derivation: C1, C2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, diags := convertWith(t, testSchema(tt.components), func(ctxt *context) {
+				ctxt.anonInline = true
+			})
+			checkDiags(t, diags, tt.want)
+			defs := lookup(decode(t, out), "definitions")
+			for _, name := range tt.recursive {
+				if lookup(defs, name) == nil {
+					t.Errorf("recursive type %s not kept as a definition:\n%s", name, out)
+				}
+			}
+			for _, name := range tt.inlined {
+				if lookup(defs, name) != nil {
+					t.Errorf("type %s not written inline:\n%s", name, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// derivation
+// complete complexContent restrictions once everything is parsed,
+// and report derivations that break the XSD rules
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+// merge each derivation with its base, then check every derivation
+// against its base
+func resolveDerivations(ctxt *context) {
+	simpleDone := make(map[xml.Name]bool)
+	for qn := range ctxt.simpleTypes {
+		resolveContentBase(qn, simpleDone, ctxt)
+	}
+	done := make(map[xml.Name]bool)
+	for qn := range ctxt.complexTypes {
+		resolveDerivation(qn, done, ctxt)
+	}
+	for _, cmplx := range ctxt.complexTypes {
+		base, ok := derivationBase(cmplx, ctxt)
+		if cmplx.derivation == "" || !ok {
+			continue
+		}
+		if base.final == "#all" || containsWord(base.final, cmplx.derivation) {
+			reportDerivation(cmplx, ctxt, "base %s is final for %s", base.name.Local, cmplx.derivation)
+		}
+		if cmplx.derivation == "restriction" {
+			checkRestriction(cmplx, base, ctxt)
+		}
+		if cmplx.mixed && !base.mixed {
+			reportDerivation(cmplx, ctxt, "mixed content not allowed by base %s", base.name.Local)
+		}
+	}
+}
+
+// merge a derived type with its base, which is resolved first
+// the base of a redefinition is the original, resolved in turn
+func resolveDerivation(qn xml.Name, done map[xml.Name]bool, ctxt *context) {
+	if done[qn] {
+		return
+	}
+	done[qn] = true
+	cmplx := ctxt.complexTypes[qn]
+	if cmplx.derivation == "" || isBuiltin(cmplx.base) {
+		return
+	}
+	if cmplx.base == qn {
+		resolveOriginal(qn, done, ctxt)
+	} else {
+		resolveDerivation(cmplx.base, done, ctxt)
+	}
+	base, ok := derivationBase(cmplx, ctxt)
+	if !ok {
+		diagnoseComponent(ctxt, sevError, typeNode{name: qn}, "base type %s not found", cmplx.base.Local)
+		return
+	}
+	ctxt.complexTypes[qn] = mergeBase(cmplx, base, ctxt)
+}
+
+// a complex type with simple content whose base was declared after it
+// becomes a copy of its base, with its own facets, attributes and
+// assertions added as if the base had been parsed first
+func resolveContentBase(qn xml.Name, done map[xml.Name]bool, ctxt *context) {
+	if done[qn] {
+		return
+	}
+	done[qn] = true
+	own := ctxt.simpleTypes[qn]
+	if own.contentBase.Local == "" {
+		return
+	}
+	resolveContentBase(own.contentBase, done, ctxt)
+	base, ok := ctxt.simpleTypes[own.contentBase]
+	if !ok {
+		if _, isComplex := ctxt.complexTypes[own.contentBase]; isComplex {
+			diagnoseComponent(ctxt, sevError, typeNode{name: qn}, "base type %s has complex content", own.contentBase.Local)
+		} else {
+			diagnoseComponent(ctxt, sevError, typeNode{name: qn}, "base type %s not found", own.contentBase.Local)
+		}
+		return
+	}
+	merged := base.clone(&qn)
+	merged.contentBase = xml.Name{}
+	merged.ann = own.ann
+	merged.attrs = append(merged.attrs, own.attrs...)
+	if own.anyAttr != nil {
+		merged.anyAttr = own.anyAttr
+	}
+	merged.enum = append(merged.enum, own.enum...)
+	merged.enumAnns = append(merged.enumAnns, own.enumAnns...)
+	for _, b := range []struct{ from, to *bound }{
+		{&own.minInclusive, &merged.minInclusive},
+		{&own.maxInclusive, &merged.maxInclusive},
+		{&own.minExclusive, &merged.minExclusive},
+		{&own.maxExclusive, &merged.maxExclusive},
+	} {
+		if b.from.set {
+			*b.to = *b.from
+		}
+	}
+	for _, n := range []struct{ from, to *int64 }{
+		{&own.totalDigits, &merged.totalDigits},
+		{&own.fractionDigits, &merged.fractionDigits},
+		{&own.length, &merged.length},
+		{&own.minLength, &merged.minLength},
+		{&own.maxLength, &merged.maxLength},
+	} {
+		if *n.from >= 0 {
+			*n.to = *n.from
+		}
+	}
+	if own.whiteSpace != "" {
+		merged.whiteSpace = own.whiteSpace
+	}
+	merged.patterns = append(merged.patterns, own.patterns...)
+	merged.asserts = append(merged.asserts, own.asserts...)
+	ctxt.simpleTypes[qn] = *merged
+}
+
+// the original of a redefined type is merged with its own base
+func resolveOriginal(qn xml.Name, done map[xml.Name]bool, ctxt *context) {
+	orig, ok := ctxt.originals[qn]
+	if !ok || done[originalName(qn)] || orig.derivation == "" || isBuiltin(orig.base) || orig.base == qn {
+		return
+	}
+	done[originalName(qn)] = true
+	resolveDerivation(orig.base, done, ctxt)
+	if base, ok := ctxt.complexTypes[orig.base]; ok {
+		ctxt.originals[qn] = mergeBase(orig, base, ctxt)
+	}
+}
+
+// merge a derived type with its resolved base
+func mergeBase(cmplx complexType, base complexType, ctxt *context) complexType {
+	if cmplx.derivation == "restriction" {
+		return restrictBase(cmplx, base, ctxt)
+	}
+	return extendBase(cmplx, base, ctxt)
+}
+
+// a restriction inherits the attributes of its base, unless it
+// redeclares or prohibits them
+func restrictBase(cmplx complexType, base complexType, ctxt *context) complexType {
+	attrs := make([]attribute, 0, len(base.attrs))
+	for _, battr := range base.attrs {
+		attr, found := findAttr(cmplx.attrs, battr.name)
+		switch {
+		case !found:
+			attrs = append(attrs, battr)
+		case attr.prohibited:
+			if battr.required {
+				reportDerivation(cmplx, ctxt, "required attribute %s prohibited", battr.name)
+			}
+		default:
+			if battr.required && !attr.required {
+				reportDerivation(cmplx, ctxt, "attribute %s must stay required", battr.name)
+			}
+			if battr.fixed != "" && attr.fixed != battr.fixed {
+				reportDerivation(cmplx, ctxt, "attribute %s must keep fixed value %s", battr.name, battr.fixed)
+			}
+			attrs = append(attrs, attr)
+		}
+	}
+	for _, attr := range cmplx.attrs {
+		if _, found := findAttr(base.attrs, attr.name); !found && !attr.prohibited {
+			if base.anyAttr != nil && base.anyAttr.allows(attr.ns) {
+				attrs = append(attrs, attr)
+				continue
+			}
+			reportDerivation(cmplx, ctxt, "attribute %s not in base %s", attr.name, base.name.Local)
+			attrs = append(attrs, attr)
+		}
+	}
+	cmplx.attrs = attrs
+	// the base's assertions still hold
+	cmplx.asserts = append(append(make([]assertion, 0), base.asserts...), cmplx.asserts...)
+	return cmplx
+}
+
+// an extension's content follows that of its base, and it adds its
+// elements, attributes and assertions to the base's
+func extendBase(cmplx complexType, base complexType, ctxt *context) complexType {
+	ext := cmplx
+	switch {
+	case base.content == nil:
+	case cmplx.content == nil:
+		ext.content = base.content.clone()
+	default:
+		seq := newCompositor("sequence")
+		seq.parts = append(seq.parts, particle{comp: base.content.clone()}, particle{comp: cmplx.content})
+		ext.content = seq
+	}
+	ext.elems = append(make([]element, 0, len(base.elems)+len(cmplx.elems)), base.elems...)
+	for _, el := range cmplx.elems {
+		if bel, found := base.findElem(el.name); found {
+			if bel.etype != el.etype {
+				reportDerivation(cmplx, ctxt, "element %s redeclared with another type", el.name)
+			}
+			continue
+		}
+		ext.elems = append(ext.elems, el)
+	}
+	ext.attrs = append(make([]attribute, 0, len(base.attrs)+len(cmplx.attrs)), base.attrs...)
+	for _, attr := range cmplx.attrs {
+		if _, found := findAttr(base.attrs, attr.name); found {
+			reportDerivation(cmplx, ctxt, "attribute %s already in base %s", attr.name, base.name.Local)
+			continue
+		}
+		ext.attrs = append(ext.attrs, attr)
+	}
+	ext.anyFlag = cmplx.anyFlag || base.anyFlag
+	ext.anyElems = append(append(make([]wildcard, 0), base.anyElems...), cmplx.anyElems...)
+	if ext.anyAttr == nil {
+		ext.anyAttr = base.anyAttr
+	}
+	ext.mixed = cmplx.mixed || base.mixed
+	ext.asserts = append(append(make([]assertion, 0), base.asserts...), cmplx.asserts...)
+	return ext
+}
+
+// the base of a derivation; that of a redefinition is the original
+func derivationBase(cmplx complexType, ctxt *context) (complexType, bool) {
+	if cmplx.base == cmplx.name {
+		base, ok := ctxt.originals[cmplx.base]
+		return base, ok
+	}
+	base, ok := ctxt.complexTypes[cmplx.base]
+	return base, ok
+}
+
+// the elements of a restriction must be in its base, occurring no more
+// and no less often, and it may only leave out optional elements
+func checkRestriction(cmplx complexType, base complexType, ctxt *context) {
+	for _, el := range cmplx.elems {
+		bel, found := base.findElem(el.name)
+		if !found {
+			if !base.anyFlag {
+				reportDerivation(cmplx, ctxt, "element %s not in base %s", el.name, base.name.Local)
+			}
+			continue
+		}
+		if occurs(el.minOccurs) < occurs(bel.minOccurs) || occurs(el.maxOccurs) > occurs(bel.maxOccurs) {
+			reportDerivation(cmplx, ctxt, "occurrences of element %s exceed those of base %s", el.name, base.name.Local)
+		}
+	}
+	for _, name := range mandatoryElems(base.content, base) {
+		if _, found := cmplx.findElem(name); !found {
+			reportDerivation(cmplx, ctxt, "mandatory element %s of base %s left out", name, base.name.Local)
+		}
+	}
+	if !base.anyFlag && cmplx.anyFlag {
+		reportDerivation(cmplx, ctxt, "wildcard not allowed by base %s", base.name.Local)
+	}
+}
+
+// the elements that must always be present for a compositor to be satisfied
+func mandatoryElems(comp *compositor, cmplx complexType) []string {
+	names := make([]string, 0)
+	if comp == nil || comp.minOccurs == 0 || comp.ctype == "choice" {
+		return names
+	}
+	for _, p := range comp.parts {
+		if p.comp != nil {
+			names = append(names, mandatoryElems(p.comp, cmplx)...)
+		} else if el, ok := cmplx.findElem(p.elem); ok && el.minOccurs != 0 {
+			names = append(names, p.elem)
+		}
+	}
+	return names
+}
+
+// find an attribute by name
+func findAttr(attrs []attribute, name string) (attribute, bool) {
+	for _, attr := range attrs {
+		if attr.name == name {
+			return attr, true
+		}
+	}
+	return attribute{}, false
+}
+
+// is the word in a whitespace separated list?
+func containsWord(list string, word string) bool {
+	for _, w := range strings.Fields(list) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// report a derivation that breaks the XSD rules
+func reportDerivation(cmplx complexType, ctxt *context, format string, v ...interface{}) {
+	diagnoseComponent(ctxt, sevError, typeNode{name: cmplx.name}, "invalid %s: %s", cmplx.derivation, fmt.Sprintf(format, v...))
+}
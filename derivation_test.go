@@ -0,0 +1,283 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// derivation tests
+// small schemas are converted, then the definitions and problems checked
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// a schema with no target namespace around some components
+func testSchema(components string) string {
+	return `<xs:schema xmlns:xs="http://www.w3.org/2001/XMLSchema">` + components + `</xs:schema>`
+}
+
+// convert a schema, returning the JSON written and the problems found
+func convert(t *testing.T, xsd string) (string, []diagnostic) {
+	t.Helper()
+	return convertWith(t, xsd, nil)
+}
+
+// convert a schema with options, set by setup if not nil
+func convertWith(t *testing.T, xsd string, setup func(ctxt *context)) (string, []diagnostic) {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "test.xsd")
+	if err := os.WriteFile(fname, []byte(xsd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctxt := newContext()
+	ctxt.inFile = fname
+	ctxt.inFileBase = filepath.Base(fname)
+	ctxt.outFileBase = "test.json"
+	ctxt.draft = 4
+	if setup != nil {
+		setup(&ctxt)
+	}
+	if _, err := parseSchema(&ctxt); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeJson(&buf, &ctxt)
+	return buf.String(), ctxt.diags
+}
+
+// the trailing commas the writer leaves
+var trailingComma = regexp.MustCompile(`,(\s*[}\]])`)
+
+// the JSON written, decoded
+func decode(t *testing.T, out string) map[string]interface{} {
+	t.Helper()
+	var j map[string]interface{}
+	if err := json.Unmarshal([]byte(trailingComma.ReplaceAllString(out, "$1")), &j); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out)
+	}
+	return j
+}
+
+// the value at a path of object keys, nil if there is none
+func lookup(j interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		obj, ok := j.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		j = obj[key]
+	}
+	return j
+}
+
+// check the problems found: one containing each expected message, and
+// no errors besides
+func checkDiags(t *testing.T, diags []diagnostic, want []string) {
+	t.Helper()
+	for _, w := range want {
+		found := false
+		for _, d := range diags {
+			found = found || strings.Contains(d.String(), w)
+		}
+		if !found {
+			t.Errorf("no problem %q among %v", w, diags)
+		}
+	}
+	for _, d := range diags {
+		expected := false
+		for _, w := range want {
+			expected = expected || strings.Contains(d.String(), w)
+		}
+		if d.sev == sevError && !expected {
+			t.Errorf("unexpected %v", d)
+		}
+	}
+}
+
+// a simple content base may be declared after the types derived from it,
+// as ISO 20022 schemas do, with the same result as before them
+func TestForwardSimpleContentBase(t *testing.T) {
+	amount := `<xs:complexType name="Amt"><xs:simpleContent><xs:extension base="AmtST">
+			<xs:attribute name="Ccy" type="xs:string" use="required"/></xs:extension></xs:simpleContent></xs:complexType>
+		<xs:complexType name="Small"><xs:simpleContent><xs:restriction base="Amt">
+			<xs:maxInclusive value="10"/><xs:pattern value="[0-9.]+"/></xs:restriction></xs:simpleContent></xs:complexType>`
+	simple := `<xs:simpleType name="AmtST"><xs:restriction base="xs:decimal">
+			<xs:minInclusive value="0"/></xs:restriction></xs:simpleType>`
+	element := `<xs:element name="Doc" type="Small"/>`
+	tests := []struct {
+		name  string
+		order string
+	}{
+		{"base first", simple + amount + element},
+		{"base last", amount + element + simple},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, diags := convert(t, testSchema(tt.order))
+			checkDiags(t, diags, nil)
+			value := lookup(decode(t, out), "definitions", "Small", "properties", "#value")
+			if lookup(value, "minimum") != 0.0 || lookup(value, "maximum") != 10.0 || lookup(value, "pattern") == nil {
+				t.Errorf("facets of base and restriction not both kept: %v", value)
+			}
+			if lookup(decode(t, out), "definitions", "Small", "properties", "@Ccy") == nil {
+				t.Errorf("attribute of base lost:\n%s", out)
+			}
+		})
+	}
+}
+
+// the base all the derivations below start from
+const derivationBaseType = `<xs:complexType name="B"><xs:sequence>
+		<xs:element name="X" type="xs:string"/>
+		<xs:element name="Y" type="xs:string" minOccurs="0" maxOccurs="3"/></xs:sequence>
+		<xs:attribute name="req" type="xs:string" use="required"/>
+		<xs:attribute name="opt" type="xs:string"/></xs:complexType>`
+
+// an extension adds to its base, a restriction keeps what it doesn't
+// prohibit or leave out
+func TestDerivationMerging(t *testing.T) {
+	tests := []struct {
+		name     string
+		derived  string
+		baseLast bool     // the base declared after the derived type
+		props    []string // the properties of the derived type
+		required []string // the properties required, in order
+	}{
+		{"extension", `<xs:complexType name="D"><xs:complexContent><xs:extension base="B">
+				<xs:sequence><xs:element name="Z" type="xs:int"/></xs:sequence>
+				<xs:attribute name="more" type="xs:string"/></xs:extension></xs:complexContent></xs:complexType>`, false,
+			[]string{"@req", "@opt", "@more", "X", "Y", "Z"}, []string{"X", "Z"}},
+		{"restriction", `<xs:complexType name="D"><xs:complexContent><xs:restriction base="B">
+				<xs:sequence><xs:element name="X" type="xs:string"/></xs:sequence>
+				<xs:attribute name="opt" use="prohibited"/></xs:restriction></xs:complexContent></xs:complexType>`, false,
+			[]string{"@req", "X"}, []string{"X"}},
+		{"restriction before its base", `<xs:complexType name="D"><xs:complexContent><xs:restriction base="B">
+				<xs:sequence><xs:element name="X" type="xs:string"/><xs:element name="Y" type="xs:string" maxOccurs="2"/></xs:sequence>
+				</xs:restriction></xs:complexContent></xs:complexType>`, true,
+			[]string{"@req", "@opt", "X", "Y"}, []string{"X", "Y"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			components := derivationBaseType + tt.derived
+			if tt.baseLast {
+				components = tt.derived + derivationBaseType
+			}
+			out, diags := convert(t, testSchema(components+`<xs:element name="Doc" type="D"/>`))
+			checkDiags(t, diags, nil)
+			d := lookup(decode(t, out), "definitions", "D")
+			props, _ := lookup(d, "properties").(map[string]interface{})
+			if len(props) != len(tt.props) {
+				t.Errorf("properties %v, want %v", props, tt.props)
+			}
+			for _, p := range tt.props {
+				if props[p] == nil {
+					t.Errorf("no property %s:\n%s", p, out)
+				}
+			}
+			if got := requiredNames(d); strings.Join(got, ",") != strings.Join(tt.required, ",") {
+				t.Errorf("required %v, want %v", got, tt.required)
+			}
+		})
+	}
+}
+
+// the names required by a definition, whether in its required or in
+// the required of its allOf
+func requiredNames(d interface{}) []string {
+	names := make([]string, 0)
+	schemas := []interface{}{d}
+	if all, ok := lookup(d, "allOf").([]interface{}); ok {
+		schemas = append(schemas, all...)
+	}
+	for _, s := range schemas {
+		required, _ := lookup(s, "required").([]interface{})
+		for _, r := range required {
+			names = append(names, r.(string))
+		}
+	}
+	return names
+}
+
+// a restriction that breaks the XSD rules is reported against the type
+func TestRestrictionErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string // of the restriction
+		want    []string
+	}{
+		{"valid", `<xs:sequence><xs:element name="X" type="xs:string"/></xs:sequence>`, nil},
+		{"prohibited required attribute", `<xs:sequence><xs:element name="X" type="xs:string"/></xs:sequence>
+				<xs:attribute name="req" use="prohibited"/>`,
+			[]string{"invalid restriction: required attribute req prohibited"}},
+		{"optional required attribute", `<xs:sequence><xs:element name="X" type="xs:string"/></xs:sequence>
+				<xs:attribute name="req" type="xs:string"/>`,
+			[]string{"invalid restriction: attribute req must stay required"}},
+		{"new attribute", `<xs:sequence><xs:element name="X" type="xs:string"/></xs:sequence>
+				<xs:attribute name="new" type="xs:string"/>`,
+			[]string{"invalid restriction: attribute new not in base B"}},
+		{"new element", `<xs:sequence><xs:element name="X" type="xs:string"/><xs:element name="Z" type="xs:string"/></xs:sequence>`,
+			[]string{"invalid restriction: element Z not in base B"}},
+		{"more occurrences", `<xs:sequence><xs:element name="X" type="xs:string"/><xs:element name="Y" type="xs:string" maxOccurs="5"/></xs:sequence>`,
+			[]string{"invalid restriction: occurrences of element Y exceed those of base B"}},
+		{"mandatory element left out", `<xs:sequence><xs:element name="Y" type="xs:string" minOccurs="0"/></xs:sequence>`,
+			[]string{"invalid restriction: mandatory element X of base B left out"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			derived := `<xs:complexType name="D"><xs:complexContent><xs:restriction base="B">` + tt.content +
+				`</xs:restriction></xs:complexContent></xs:complexType><xs:element name="Doc" type="D"/>`
+			_, diags := convert(t, testSchema(derivationBaseType+derived))
+			checkDiags(t, diags, tt.want)
+			for _, d := range diags {
+				if d.sev == sevError && !strings.Contains(d.String(), "complexType[D]") {
+					t.Errorf("not reported against D: %v", d)
+				}
+			}
+		})
+	}
+}
+
+// the occurrences of a repeating element bound its array
+func TestOccurrences(t *testing.T) {
+	tests := []struct {
+		name     string
+		occurs   string
+		minItems interface{}
+		maxItems interface{}
+	}{
+		{"optional", `minOccurs="0" maxOccurs="3"`, nil, 3.0},
+		{"at least two", `minOccurs="2" maxOccurs="unbounded"`, 2.0, nil},
+		{"range", `minOccurs="2" maxOccurs="5"`, 2.0, 5.0},
+		{"exactly four", `minOccurs="4" maxOccurs="4"`, 4.0, 4.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, diags := convert(t, testSchema(`<xs:complexType name="T"><xs:sequence>
+					<xs:element name="R" type="xs:string" `+tt.occurs+`/></xs:sequence></xs:complexType>
+				<xs:element name="Doc" type="T"/>`))
+			checkDiags(t, diags, nil)
+			r := lookup(decode(t, out), "definitions", "T", "properties", "R")
+			if lookup(r, "type") != "array" || lookup(r, "minItems") != tt.minItems || lookup(r, "maxItems") != tt.maxItems {
+				t.Errorf("got %v, want minItems %v maxItems %v", r, tt.minItems, tt.maxItems)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// diagnostics
+// problems found in the schema, with where they were found
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+)
+
+type severity int
+
+const (
+	sevWarning severity = iota // converted, but perhaps not as intended
+	sevError                   // the schema is wrong, or something is missing
+)
+
+func (s severity) String() string {
+	if s == sevError {
+		return "error"
+	}
+	return "warning"
+}
+
+// a problem found in the schema
+// those found after parsing are placed where the component was declared;
+// path names the component if that isn't known
+type diagnostic struct {
+	sev    severity
+	file   string
+	line   int
+	column int
+	path   string // the XSD component, e.g. /schema/complexType[Party]/sequence
+	msg    string
+}
+
+func (d diagnostic) String() string {
+	where := ""
+	if d.file != "" {
+		where = fmt.Sprintf("%s:%d:%d: ", d.file, d.line, d.column)
+	}
+	s := fmt.Sprintf("%s%s: %s", where, d.sev, d.msg)
+	if d.path != "" {
+		s += " (" + d.path + ")"
+	}
+	return s
+}
+
+// where a component is declared
+type position struct {
+	file   string
+	line   int
+	column int
+	path   string
+}
+
+// the start of the XSD element being parsed
+func currentPosition(ctxt *context) position {
+	n := len(ctxt.fileStack)
+	if n == 0 {
+		return position{}
+	}
+	return position{file: ctxt.fileStack[n-1], line: ctxt.line, column: ctxt.column, path: "/" + strings.Join(ctxt.pathStack, "/")}
+}
+
+// record and print a problem
+// while parsing, it is placed at the start of the current XSD element
+func diagnose(ctxt *context, sev severity, format string, v ...interface{}) {
+	pos := currentPosition(ctxt)
+	d := diagnostic{sev: sev, file: pos.file, line: pos.line, column: pos.column, path: pos.path, msg: fmt.Sprintf(format, v...)}
+	ctxt.diags = append(ctxt.diags, d)
+	fmt.Println(d)
+}
+
+// record and print a problem at a position in a file other than a schema
+func diagnoseAt(ctxt *context, sev severity, file string, line int, column int, format string, v ...interface{}) {
+	d := diagnostic{sev: sev, file: file, line: line, column: column, msg: fmt.Sprintf(format, v...)}
+	ctxt.diags = append(ctxt.diags, d)
+	fmt.Println(d)
+}
+
+// record and print a problem with a type or global element, found after
+// parsing; it is placed where the component was declared, if known
+func diagnoseComponent(ctxt *context, sev severity, component typeNode, format string, v ...interface{}) {
+	d := diagnostic{sev: sev, path: component.name.Local, msg: fmt.Sprintf(format, v...)}
+	if pos, ok := ctxt.positions[component]; ok {
+		d.file, d.line, d.column, d.path = pos.file, pos.line, pos.column, pos.path
+	}
+	ctxt.diags = append(ctxt.diags, d)
+	fmt.Println(d)
+}
+
+// the number of errors
+func errorCount(diags []diagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.sev == sevError {
+			n++
+		}
+	}
+	return n
+}
+
+// the path segment of an XSD element: its name, and that of the
+// component it declares or refers to
+func pathSegment(el *xml.StartElement) string {
+	ref := ""
+	for _, attr := range el.Attr {
+		switch {
+		case attr.Name.Space != "":
+		case attr.Name.Local == "name":
+			return el.Name.Local + "[" + attr.Value + "]"
+		case attr.Name.Local == "ref":
+			ref = attr.Value
+		}
+	}
+	if ref != "" {
+		return el.Name.Local + "[" + ref + "]"
+	}
+	return el.Name.Local
+}
@@ -0,0 +1,183 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// elements
+// resolve element and attribute ref= against the global ones once everything
+// is parsed, and work out substitution groups and derived types
+
+package main
+
+import (
+	"encoding/xml"
+)
+
+// give every element reference the type of the global element it refers to
+// the occurrence bounds stay those of the reference
+func resolveElementRefs(ctxt *context) {
+	for qn, cmplx := range ctxt.complexTypes {
+		for i, el := range cmplx.elems {
+			if el.ref.Local == "" {
+				continue
+			}
+			global, ok := ctxt.elements[el.ref]
+			if !ok {
+				diagnoseComponent(ctxt, sevError, typeNode{name: qn}, "element %s not found", el.ref.Local)
+				continue
+			}
+			cmplx.elems[i].etype = global.etype
+		}
+	}
+}
+
+// give every attribute reference the type, and any fixed or default
+// value, of the global attribute it refers to; those in the XML namespace
+// are known without xml.xsd
+func resolveAttrRefs(ctxt *context) {
+	for qn, cmplx := range ctxt.complexTypes {
+		resolveAttrs(cmplx.attrs, qn, ctxt)
+	}
+	for qn, simple := range ctxt.simpleTypes {
+		resolveAttrs(simple.attrs, qn, ctxt)
+	}
+}
+
+// resolve the attribute references of a type
+func resolveAttrs(attrs []attribute, owner xml.Name, ctxt *context) {
+	for i, attr := range attrs {
+		if attr.ref.Local == "" {
+			continue
+		}
+		global, ok := ctxt.attributes[attr.ref]
+		if !ok {
+			if xmlType, isXml := xmlAttrTypes[attr.ref.Local]; isXml && attr.ref.Space == xmlNs {
+				attrs[i].atype = xml.Name{Space: xsdNs, Local: xmlType}
+				continue
+			}
+			diagnoseComponent(ctxt, sevError, typeNode{name: owner}, "attribute %s not found", attr.ref.Local)
+			continue
+		}
+		attrs[i].atype = global.atype
+		if attr.fixed == "" {
+			attrs[i].fixed = global.fixed
+		}
+		if attr.adefault == "" {
+			attrs[i].adefault = global.adefault
+		}
+		if attr.ann.isEmpty() {
+			attrs[i].ann = global.ann
+		}
+	}
+}
+
+// report every type referred to that was never defined, e.g. because its
+// schema could not be loaded, as it would be written as a dangling $ref
+// the base of a complex type is checked as its derivation is resolved
+func checkTypeRefs(ctxt *context) {
+	for qn, el := range ctxt.elements {
+		checkTypeRef(el.etype, typeNode{element: true, name: qn}, "type", "", ctxt)
+	}
+	for qn, cmplx := range ctxt.complexTypes {
+		for _, el := range cmplx.elems {
+			if el.ref.Local == "" {
+				checkTypeRef(el.etype, typeNode{name: qn}, "type", "element "+el.name, ctxt)
+			}
+		}
+		for _, attr := range cmplx.attrs {
+			checkTypeRef(attr.atype, typeNode{name: qn}, "type", "attribute "+attr.name, ctxt)
+		}
+	}
+	for qn, simple := range ctxt.simpleTypes {
+		checkTypeRef(simple.base, typeNode{name: qn}, "base type", "", ctxt)
+		checkTypeRef(simple.itemType, typeNode{name: qn}, "item type", "", ctxt)
+		for _, member := range simple.memberTypes {
+			checkTypeRef(member, typeNode{name: qn}, "member type", "", ctxt)
+		}
+		for _, attr := range simple.attrs {
+			checkTypeRef(attr.atype, typeNode{name: qn}, "type", "attribute "+attr.name, ctxt)
+		}
+	}
+}
+
+// report a type that is neither a builtin nor defined, e.g. the "type"
+// of "attribute Ccy", or a "base type"
+func checkTypeRef(qn xml.Name, component typeNode, kind string, of string, ctxt *context) {
+	_, isSimple := ctxt.simpleTypes[qn]
+	_, isComplex := ctxt.complexTypes[qn]
+	switch {
+	case qn.Local == "" || isBuiltin(qn) || isSimple || isComplex:
+	case of == "":
+		diagnoseComponent(ctxt, sevError, component, "%s %s not found", kind, qn.Local)
+	default:
+		diagnoseComponent(ctxt, sevError, component, "%s %s of %s not found", kind, qn.Local, of)
+	}
+}
+
+// the types of the attributes in the XML namespace
+var xmlAttrTypes = map[string]string{
+	"lang":  "language",
+	"space": "NCName",
+	"base":  "anyURI",
+	"id":    "ID",
+}
+
+// the property name of a referenced attribute, which keeps the xml:
+// prefix of those in the XML namespace
+func attrRefName(ref xml.Name) string {
+	if ref.Space == xmlNs {
+		return "xml:" + ref.Local
+	}
+	return ref.Local
+}
+
+// the concrete (non-abstract) global elements that may appear in place of
+// the head of a substitution group, including members of members
+func substMembers(head xml.Name, ctxt *context) []xml.Name {
+	members := make([]xml.Name, 0)
+	active := []xml.Name{head}
+	for i := 0; i < len(active); i++ {
+		for qn, el := range ctxt.elements {
+			if containsName(el.substGroups, active[i]) && !containsName(active, qn) {
+				active = append(active, qn)
+				if !el.abstract {
+					members = append(members, qn)
+				}
+			}
+		}
+	}
+	sortNames(members)
+	return members
+}
+
+// the concrete complex types derived (directly or indirectly) from a type
+func derivedTypes(base xml.Name, ctxt *context) []xml.Name {
+	derived := make([]xml.Name, 0)
+	for qn, cmplx := range ctxt.complexTypes {
+		if cmplx.abstract || isAnonymous(qn) { // neither can be named by xsi:type
+			continue
+		}
+		// walk up the chain of bases, stopping at a loop
+		seen := []xml.Name{qn}
+		for b := cmplx.base; b.Local != "" && !containsName(seen, b); b = ctxt.complexTypes[b].base {
+			if b == base {
+				derived = append(derived, qn)
+				break
+			}
+			seen = append(seen, b)
+		}
+	}
+	sortNames(derived)
+	return derived
+}
@@ -0,0 +1,139 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// groups
+// expand xs:group and xs:attributeGroup references once everything is parsed,
+// so that forward references work
+
+package main
+
+import (
+	"encoding/xml"
+)
+
+// expand all group and attribute group references
+func resolveGroups(ctxt *context) {
+	for qn, cmplx := range ctxt.complexTypes {
+		cmplx.attrs = append(cmplx.attrs, expandAttrGroups(cmplx.attrGroups, qn, nil, ctxt)...)
+		if cmplx.anyAttr == nil {
+			cmplx.anyAttr = attrGroupWildcard(cmplx.attrGroups, nil, ctxt)
+		}
+		cmplx.attrGroups = nil
+		cmplx.content = expandGroups(cmplx.content, &cmplx, nil, ctxt)
+		ctxt.complexTypes[qn] = cmplx
+	}
+	for qn, simple := range ctxt.simpleTypes {
+		simple.attrs = append(simple.attrs, expandAttrGroups(simple.attrGroups, qn, nil, ctxt)...)
+		if simple.anyAttr == nil {
+			simple.anyAttr = attrGroupWildcard(simple.attrGroups, nil, ctxt)
+		}
+		simple.attrGroups = nil
+		ctxt.simpleTypes[qn] = simple
+	}
+}
+
+// the attributes of a list of attribute groups, including nested groups
+// owner names the type referring to them, for diagnostics; active holds
+// the groups being expanded, to stop circular references
+func expandAttrGroups(refs []xml.Name, owner xml.Name, active []xml.Name, ctxt *context) []attribute {
+	attrs := make([]attribute, 0)
+	for _, ref := range refs {
+		if containsName(active, ref) {
+			diagnoseComponent(ctxt, sevError, typeNode{name: owner}, "circular attributeGroup %s ignored", ref.Local)
+			continue
+		}
+		group, ok := ctxt.attrGroups[ref]
+		if !ok {
+			diagnoseComponent(ctxt, sevError, typeNode{name: owner}, "attributeGroup %s not found", ref.Local)
+			continue
+		}
+		attrs = append(attrs, group.attrs...)
+		attrs = append(attrs, expandAttrGroups(group.attrGroups, owner, append(active, ref), ctxt)...)
+	}
+	return attrs
+}
+
+// the first xs:anyAttribute within a list of attribute groups, if any
+func attrGroupWildcard(refs []xml.Name, active []xml.Name, ctxt *context) *wildcard {
+	for _, ref := range refs {
+		group, ok := ctxt.attrGroups[ref]
+		if !ok || containsName(active, ref) {
+			continue
+		}
+		if group.anyAttr != nil {
+			return group.anyAttr
+		}
+		if w := attrGroupWildcard(group.attrGroups, append(active, ref), ctxt); w != nil {
+			return w
+		}
+	}
+	return nil
+}
+
+// replace group references within a compositor by a copy of the group's
+// compositor, carrying the occurrence bounds of the reference.
+// The group's elements are added to the complex type.
+func expandGroups(comp *compositor, cmplx *complexType, active []xml.Name, ctxt *context) *compositor {
+	if comp == nil {
+		return nil
+	}
+	if comp.ctype == "group" {
+		if containsName(active, comp.ref) {
+			diagnoseComponent(ctxt, sevError, typeNode{name: cmplx.name}, "circular group %s ignored", comp.ref.Local)
+			return nil
+		}
+		group, ok := ctxt.groups[comp.ref]
+		if !ok || group.content == nil {
+			diagnoseComponent(ctxt, sevError, typeNode{name: cmplx.name}, "group %s not found", comp.ref.Local)
+			return nil
+		}
+		for _, el := range group.elems {
+			if _, found := cmplx.findElem(el.name); !found {
+				cmplx.elems = append(cmplx.elems, el)
+			}
+		}
+		if group.anyFlag {
+			cmplx.anyFlag = true
+			cmplx.anyElems = append(cmplx.anyElems, group.anyElems...)
+		}
+		expanded := group.content.clone()
+		expanded.minOccurs = comp.minOccurs
+		expanded.maxOccurs = comp.maxOccurs
+		return expandGroups(expanded, cmplx, append(active, comp.ref), ctxt)
+	}
+	parts := make([]particle, 0, len(comp.parts))
+	for _, p := range comp.parts {
+		if p.comp != nil {
+			p.comp = expandGroups(p.comp, cmplx, active, ctxt)
+			if p.comp == nil {
+				continue
+			}
+		}
+		parts = append(parts, p)
+	}
+	comp.parts = parts
+	return comp
+}
+
+// is a name in the list?
+func containsName(names []xml.Name, qn xml.Name) bool {
+	for _, n := range names {
+		if n == qn {
+			return true
+		}
+	}
+	return false
+}
@@ -36,17 +36,25 @@ func main() {
 	ctxt := newContext()
 	cmdLineParse(&ctxt)
 
-	// open the input file
-	fname := ctxt.inFile
-	inf, err := os.Open(fname)
+	// load the catalog, if any
+	if ctxt.catFile != "" {
+		cat, err := loadCatalog(ctxt.catFile, &ctxt)
+		if err != nil {
+			fmt.Printf("Catalog %v load err %v", ctxt.catFile, err)
+			os.Exit(2)
+		}
+		ctxt.catalog = cat
+	}
+
+	// parse the input file and anything it includes
+	_, err := parseSchema(&ctxt)
 	if err != nil {
-		fmt.Printf("File %v open err %v", fname, err)
+		fmt.Printf("File %v open err %v", ctxt.inFile, err)
 		os.Exit(2)
 	}
-	defer inf.Close()
 
 	// open the output file
-	fname = ctxt.outFile
+	fname := ctxt.outFile
 	outf, err := os.Create(fname)
 	if err != nil {
 		fmt.Printf("File %v open err %v", fname, err)
@@ -54,7 +62,13 @@ func main() {
 	}
 	defer outf.Close()
 
-	parseXml(inf, &ctxt)
 	writeJson(outf, &ctxt)
 
+	// the schema is still written, but it may be incomplete
+	// problems found while writing count too
+	if n := errorCount(ctxt.diags); n > 0 {
+		fmt.Printf("%d errors in %s\n", n, ctxt.inFile)
+		outf.Close()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,227 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// namespace
+// track xmlns declarations and resolve QNames to namespace + local name
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const xmlNs = "http://www.w3.org/XML/1998/namespace"
+
+// per-file namespace state
+type schemaScope struct {
+	nsStack       []map[string]string // prefix -> namespace, one map per open element
+	targetNs      string
+	elemQualified bool   // elementFormDefault="qualified"
+	attrQualified bool   // attributeFormDefault="qualified"
+	chameleonNs   string // namespace adopted by an include with no targetNamespace
+}
+
+// push the xmlns declarations of an element (may be none)
+func pushNamespaces(el *xml.StartElement, ctxt *context) {
+	decls := make(map[string]string)
+	for _, attr := range el.Attr {
+		switch {
+		case attr.Name.Space == "xmlns":
+			decls[attr.Name.Local] = attr.Value
+			// remember the first prefix seen for naming definitions
+			if prefix, seen := ctxt.nsPrefixes[attr.Value]; !seen || prefix == "" {
+				ctxt.nsPrefixes[attr.Value] = attr.Name.Local
+			}
+		case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+			decls[""] = attr.Value
+			if _, seen := ctxt.nsPrefixes[attr.Value]; !seen {
+				ctxt.nsPrefixes[attr.Value] = ""
+			}
+		}
+	}
+	ctxt.scope.nsStack = append(ctxt.scope.nsStack, decls)
+}
+
+// pop the declarations pushed by the matching start element
+func popNamespaces(ctxt *context) {
+	ctxt.scope.nsStack = ctxt.scope.nsStack[:len(ctxt.scope.nsStack)-1]
+}
+
+// find the namespace bound to a prefix ("" is the default namespace)
+func lookupPrefix(prefix string, ctxt *context) (string, bool) {
+	if prefix == "xml" {
+		return xmlNs, true
+	}
+	for i := len(ctxt.scope.nsStack) - 1; i >= 0; i-- {
+		if ns, ok := ctxt.scope.nsStack[i][prefix]; ok {
+			return ns, true
+		}
+	}
+	return "", prefix == ""
+}
+
+// resolve a QName attribute value (type=, base=, ref= ...)
+// using the xmlns declarations currently in scope
+func resolveQName(value string, ctxt *context) xml.Name {
+	prefix, local := "", value
+	if idx := strings.Index(value, ":"); idx > -1 {
+		prefix, local = value[:idx], value[idx+1:]
+	}
+	ns, found := lookupPrefix(prefix, ctxt)
+	if !found {
+		diagnose(ctxt, sevError, "undeclared namespace prefix %s in %s", prefix, value)
+	}
+	if ns == "" {
+		ns = ctxt.scope.chameleonNs
+	}
+	return xml.Name{Space: ns, Local: local}
+}
+
+// the qualified name of a component declared in the current schema
+func targetName(local string, ctxt *context) xml.Name {
+	return xml.Name{Space: ctxt.scope.targetNs, Local: local}
+}
+
+// the namespace of a local element or attribute, given its form attribute
+// and the schema default; unqualified means no namespace
+func formNamespace(form string, qualifiedDefault bool, ctxt *context) string {
+	if form == "qualified" || (form == "" && qualifiedDefault) {
+		return ctxt.scope.targetNs
+	}
+	return ""
+}
+
+// the name of the definition for a type
+func defName(qn xml.Name, ctxt *context) string {
+	if name, ok := ctxt.defNames[qn]; ok {
+		return name
+	}
+	return qn.Local
+}
+
+// is this the internal name of an anonymous type?
+func isAnonymous(qn xml.Name) bool {
+	return strings.HasPrefix(qn.Local, "/")
+}
+
+// assign a definition name to every type and global element
+// types are named by qualifyClashes; anonymous types are then named
+// from their path, e.g. Document_GrpHdr. Global elements are named
+// "element." and their (qualified) name, so they never clash with types.
+func assignDefNames(ctxt *context) {
+	names := make([]xml.Name, 0)
+	anons := make([]xml.Name, 0)
+	for qn := range ctxt.simpleTypes {
+		if isAnonymous(qn) {
+			anons = append(anons, qn)
+		} else {
+			names = append(names, qn)
+		}
+	}
+	for qn := range ctxt.complexTypes {
+		if isAnonymous(qn) {
+			anons = append(anons, qn)
+		} else {
+			names = append(names, qn)
+		}
+	}
+	for qn, name := range qualifyClashes(names, ctxt) {
+		ctxt.defNames[qn] = name
+	}
+
+	taken := make(map[string]bool)
+	for _, name := range ctxt.defNames {
+		taken[name] = true
+	}
+	sortNames(anons)
+	for _, qn := range anons {
+		base := strings.Replace(strings.Replace(qn.Local[1:], "@", "", -1), "/", "_", -1)
+		name := base
+		for i := 2; taken[name]; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+		taken[name] = true
+		ctxt.defNames[qn] = name
+	}
+
+	elems := make([]xml.Name, 0, len(ctxt.elements))
+	for qn := range ctxt.elements {
+		elems = append(elems, qn)
+	}
+	for qn, name := range qualifyClashes(elems, ctxt) {
+		ctxt.elemDefNames[qn] = "element." + name
+	}
+}
+
+// the name of the definition for a global element
+func elemDefName(qn xml.Name, ctxt *context) string {
+	if name, ok := ctxt.elemDefNames[qn]; ok {
+		return name
+	}
+	return "element." + qn.Local
+}
+
+// name each component by its local name, unless it clashes with one of the
+// same name in another namespace, in which case the namespace prefix is added.
+// Names in the main schema's namespace always keep their local name.
+func qualifyClashes(names []xml.Name, ctxt *context) map[xml.Name]string {
+	sortNames(names)
+	byLocal := make(map[string][]xml.Name)
+	for _, qn := range names {
+		byLocal[qn.Local] = append(byLocal[qn.Local], qn)
+	}
+	qualified := make(map[xml.Name]string)
+	for _, qn := range names {
+		if len(byLocal[qn.Local]) == 1 || qn.Space == ctxt.mainNs {
+			qualified[qn] = qn.Local
+			continue
+		}
+		prefix := ctxt.nsPrefixes[qn.Space]
+		if prefix == "" {
+			prefix = fmt.Sprintf("ns%d", nsIndex(qn.Space, ctxt))
+		}
+		qualified[qn] = prefix + "_" + qn.Local
+	}
+	return qualified
+}
+
+// sort names by local name, then namespace
+func sortNames(names []xml.Name) {
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Local != names[j].Local {
+			return names[i].Local < names[j].Local
+		}
+		return names[i].Space < names[j].Space
+	})
+}
+
+// a stable number for a namespace with no prefix
+func nsIndex(ns string, ctxt *context) int {
+	all := make([]string, 0, len(ctxt.nsPrefixes))
+	for n := range ctxt.nsPrefixes {
+		all = append(all, n)
+	}
+	sort.Strings(all)
+	for i, n := range all {
+		if n == ns {
+			return i
+		}
+	}
+	return len(all)
+}
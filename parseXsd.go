@@ -23,9 +23,263 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 )
 
+// a component being parsed
+// type frames save the enclosing types while a nested (anonymous) type
+// is parsed; element and attribute frames receive any anonymous type.
+type parseFrame struct {
+	isType    bool
+	smplType  *simpleType
+	cplxType  *complexType
+	compStack []*compositor
+	name      string              // path segment, empty for anonymous types
+	setType   func(xml.Name)      // assigns an anonymous type to its owner
+	getAnn    func() *annotations // where annotations of this component go
+	anonName  xml.Name            // set if this frame is an anonymous type
+}
+
+// push an element, attribute or restriction that may own an anonymous type
+// or be annotated
+func pushOwner(name string, setType func(xml.Name), getAnn func() *annotations, ctxt *context) {
+	ctxt.frames = append(ctxt.frames, parseFrame{name: name, setType: setType, getAnn: getAnn})
+}
+
+// start a simple or complex type definition, saving any enclosing type
+// returns the name to use, synthesised from the path if anonymous
+func pushType(name string, ctxt *context) xml.Name {
+	frame := parseFrame{
+		isType:    true,
+		smplType:  ctxt.smplType,
+		cplxType:  ctxt.cplxType,
+		compStack: ctxt.compStack,
+		name:      name,
+		getAnn: func() *annotations {
+			if ctxt.smplType != nil {
+				return &ctxt.smplType.ann
+			}
+			return &ctxt.cplxType.ann
+		},
+	}
+	qn := targetName(name, ctxt)
+	if name == "" {
+		qn = anonTypeName(ctxt)
+		frame.anonName = qn
+		if n := len(ctxt.frames); n > 0 {
+			frame.setType = ctxt.frames[n-1].setType
+		}
+		if frame.setType == nil {
+			diagnose(ctxt, sevError, "anonymous type has no owner")
+		}
+	}
+	ctxt.positions[typeNode{name: qn}] = currentPosition(ctxt)
+	ctxt.frames = append(ctxt.frames, frame)
+	ctxt.smplType = nil
+	ctxt.cplxType = nil
+	ctxt.compStack = make([]*compositor, 0)
+	return qn
+}
+
+// end a simple or complex type definition
+// an anonymous type is handed to its owner, then the enclosing type restored
+func popType(ctxt *context) {
+	frame := popFrame(ctxt)
+	if frame.setType != nil {
+		frame.setType(frame.anonName)
+	}
+	ctxt.smplType = frame.smplType
+	ctxt.cplxType = frame.cplxType
+	ctxt.compStack = frame.compStack
+}
+
+// parse minOccurs / maxOccurs
+func parseOccurs(value string) int64 {
+	if value == "unbounded" {
+		return 9999999
+	}
+	n, _ := strconv.ParseInt(value, 10, 64)
+	return n
+}
+
+// set a facet of a simple type
+func setFacet(facet string, value string, smpl *simpleType) {
+	switch facet {
+	case "minInclusive":
+		smpl.minInclusive = parseBound(value)
+	case "maxInclusive":
+		smpl.maxInclusive = parseBound(value)
+	case "minExclusive":
+		smpl.minExclusive = parseBound(value)
+	case "maxExclusive":
+		smpl.maxExclusive = parseBound(value)
+	case "totalDigits":
+		smpl.totalDigits, _ = strconv.ParseInt(value, 10, 64)
+	case "fractionDigits":
+		smpl.fractionDigits, _ = strconv.ParseInt(value, 10, 64)
+	case "length":
+		smpl.length, _ = strconv.ParseInt(value, 10, 64)
+	case "minLength":
+		smpl.minLength, _ = strconv.ParseInt(value, 10, 64)
+	case "maxLength":
+		smpl.maxLength, _ = strconv.ParseInt(value, 10, 64)
+	case "whiteSpace":
+		smpl.whiteSpace = value
+	case "pattern":
+		if len(smpl.patterns) == 0 {
+			smpl.patterns = append(smpl.patterns, []string{})
+		}
+		step := len(smpl.patterns) - 1
+		smpl.patterns[step] = append(smpl.patterns[step], value)
+	}
+}
+
+// start a compositor
+// the outermost one becomes the content of the complex type
+func pushCompositor(comp *compositor, ctxt *context) {
+	cplx := ctxt.cplxType
+	if n := len(ctxt.compStack); n > 0 {
+		top := ctxt.compStack[n-1]
+		top.parts = append(top.parts, particle{comp: comp})
+	} else {
+		cplx.content = comp
+	}
+	ctxt.compStack = append(ctxt.compStack, comp)
+}
+
+// end a compositor
+func popCompositor(ctxt *context) {
+	ctxt.compStack = ctxt.compStack[:len(ctxt.compStack)-1]
+}
+
+// end any frame
+func popFrame(ctxt *context) parseFrame {
+	n := len(ctxt.frames) - 1
+	frame := ctxt.frames[n]
+	ctxt.frames = ctxt.frames[:n]
+	return frame
+}
+
+// the internal name of an anonymous type: the path of enclosing components
+// The leading "/" keeps it apart from named types, since it can't occur
+// in an NCName; a readable definition name is assigned when writing.
+func anonTypeName(ctxt *context) xml.Name {
+	path := make([]string, 0)
+	for _, frame := range ctxt.frames {
+		if frame.name != "" {
+			path = append(path, frame.name)
+		}
+	}
+	qn := targetName("/"+strings.Join(path, "/"), ctxt)
+	base := qn.Local
+	for i := 2; ; i++ {
+		_, isSimple := ctxt.simpleTypes[qn]
+		_, isComplex := ctxt.complexTypes[qn]
+		if !isSimple && !isComplex && !anonInProgress(qn, ctxt) {
+			return qn
+		}
+		qn.Local = fmt.Sprintf("%s/%d", base, i)
+	}
+}
+
+// is an enclosing anonymous type already using this name?
+func anonInProgress(qn xml.Name, ctxt *context) bool {
+	for _, frame := range ctxt.frames {
+		if frame.anonName == qn {
+			return true
+		}
+	}
+	return false
+}
+
+// parse the main schema and everything it includes, then resolve the
+// references between components; returns the problems found
+func parseSchema(ctxt *context) ([]diagnostic, error) {
+	if err := parseFile(ctxt.inFile, "", ctxt); err != nil {
+		return ctxt.diags, err
+	}
+	resolveGroups(ctxt)
+	resolveDerivations(ctxt)
+	resolveElementRefs(ctxt)
+	resolveAttrRefs(ctxt)
+	checkTypeRefs(ctxt)
+	translateAssertions(ctxt)
+	analyseCycles(ctxt)
+	selectRoots(ctxt)
+	return ctxt.diags, nil
+}
+
+// open and parse a schema file, merging its types into the context
+// files already parsed are skipped, so diamond includes are harmless.
+// chameleonNs is the includer's namespace, adopted if the file has none.
+func parseFile(fname string, chameleonNs string, ctxt *context) error {
+	abs, err := filepath.Abs(fname)
+	if err != nil {
+		return err
+	}
+	for _, open := range ctxt.fileStack {
+		if open == abs {
+			diagnose(ctxt, sevWarning, "include cycle: %s already being parsed, skipped", fname)
+			return nil
+		}
+	}
+	if ctxt.loaded[abs] {
+		return nil
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctxt.loaded[abs] = true
+	ctxt.fileStack = append(ctxt.fileStack, abs)
+	saved, savedPath, line, column := ctxt.scope, ctxt.pathStack, ctxt.line, ctxt.column
+	ctxt.scope = schemaScope{
+		nsStack:     make([]map[string]string, 0),
+		chameleonNs: chameleonNs,
+	}
+	ctxt.pathStack = make([]string, 0)
+	parseXml(f, ctxt)
+	ctxt.scope, ctxt.pathStack, ctxt.line, ctxt.column = saved, savedPath, line, column
+	ctxt.fileStack = ctxt.fileStack[:len(ctxt.fileStack)-1]
+	return nil
+}
+
+// follow an xs:include or xs:import
+// the catalog is consulted first (by location, then by namespace),
+// otherwise the location is taken relative to the including file
+func includeSchema(location string, namespace string, isImport bool, ctxt *context) {
+	fname, found := ctxt.catalog.lookup(location)
+	if !found {
+		fname, found = ctxt.catalog.lookup(namespace)
+	}
+	if !found {
+		if location == "" {
+			diagnose(ctxt, sevWarning, "import of namespace %s has no schemaLocation, skipped", namespace)
+			return
+		}
+		fname = location
+		if !isRemote(fname) && !filepath.IsAbs(fname) && len(ctxt.fileStack) > 0 {
+			fname = filepath.Join(filepath.Dir(ctxt.fileStack[len(ctxt.fileStack)-1]), fname)
+		}
+	}
+	if isRemote(fname) {
+		diagnose(ctxt, sevWarning, "remote schema %s not fetched, use a catalog to map it to a local file", fname)
+		return
+	}
+	chameleonNs := ctxt.scope.targetNs
+	if isImport {
+		chameleonNs = ""
+	}
+	if err := parseFile(fname, chameleonNs, ctxt); err != nil {
+		diagnose(ctxt, sevError, "cannot open %s: %v", location, err)
+	}
+}
+
 // parse the XML file handle and populate the context
 func parseXml(f io.Reader, ctxt *context) {
 
@@ -33,18 +287,38 @@ func parseXml(f io.Reader, ctxt *context) {
 	decoder := xml.NewDecoder(f)
 	for {
 		// Read tokens from the XML document in a stream.
-		t, _ := decoder.Token()
-		if t == nil { // EOF
+		ctxt.line, ctxt.column = decoder.InputPos()
+		t, err := decoder.Token()
+		if err != nil {
+			if err != io.EOF {
+				diagnose(ctxt, sevError, "%v", err)
+			}
 			break
 		}
 		// Inspect the type of the token just read.
 		switch el := t.(type) {
 		case xml.StartElement:
-			startElement(&el, ctxt)
+			pushNamespaces(&el, ctxt)
+			if ctxt.annot.depth > 0 {
+				annotationStart(&el, ctxt)
+			} else {
+				ctxt.pathStack = append(ctxt.pathStack, pathSegment(&el))
+				startElement(&el, ctxt)
+				ctxt.xsdStack = append(ctxt.xsdStack, el.Name.Local)
+			}
 		case xml.EndElement:
-			endElement(&el, ctxt)
+			if ctxt.annot.depth > 0 {
+				annotationEnd(&el, ctxt)
+			} else {
+				ctxt.xsdStack = ctxt.xsdStack[:len(ctxt.xsdStack)-1]
+				endElement(&el, ctxt)
+				ctxt.pathStack = ctxt.pathStack[:len(ctxt.pathStack)-1]
+			}
+			popNamespaces(ctxt)
 		case xml.CharData:
-			// fmt.Printf("charData: %v\n", el)
+			if ctxt.annot.depth > 0 {
+				annotationText(el, ctxt)
+			}
 		case xml.Comment:
 			// fmt.Printf("comment: %v\n", el)
 		case xml.Directive:
@@ -54,7 +328,7 @@ func parseXml(f io.Reader, ctxt *context) {
 		// case io.EOF:
 		// 	fmt.Printf("End Of File")
 		default:
-			fmt.Printf("WTF? %v", el)
+			diagnose(ctxt, sevWarning, "unexpected XML %v ignored", el)
 		}
 	}
 
@@ -62,9 +336,12 @@ func parseXml(f io.Reader, ctxt *context) {
 
 func startElement(el *xml.StartElement, ctxt *context) {
 	// convert attrs into map (duplicate attrs will be lost)
+	// namespaced attributes (including xmlns declarations) are left out
 	attrs := make(map[string]string)
 	for _, attr := range el.Attr {
-		attrs[attr.Name.Local] = attr.Value
+		if attr.Name.Space == "" && attr.Name.Local != "xmlns" {
+			attrs[attr.Name.Local] = attr.Value
+		}
 	}
 	switch el.Name.Local {
 	case "element":
@@ -76,132 +353,331 @@ func startElement(el *xml.StartElement, ctxt *context) {
 			case "name":
 				elem.name = value
 			case "type":
-				elem.etype = value
+				elem.etype = resolveQName(value, ctxt)
+			case "ref":
+				elem.ref = resolveQName(value, ctxt)
+				elem.name = elem.ref.Local
+			case "form": // used below
+			case "abstract":
+				elem.abstract = value == "true"
+			case "substitutionGroup": // XSD 1.1 allows several heads
+				for _, head := range strings.Fields(value) {
+					elem.substGroups = append(elem.substGroups, resolveQName(head, ctxt))
+				}
+			case "default":
+				elem.edefault = value
+			case "fixed":
+				elem.fixed = value
+			case "nillable":
+				elem.nillable = value == "true"
 			case "minOccurs":
-				elem.minOccurs, _ = strconv.ParseInt(value, 10, 64)
+				elem.minOccurs = parseOccurs(value)
 			case "maxOccurs":
-				if value == "unbounded" {
-					elem.maxOccurs = 9999999
-				} else {
-					elem.maxOccurs, _ = strconv.ParseInt(value, 10, 64)
-				}
+				elem.maxOccurs = parseOccurs(value)
 			default:
-				fmt.Printf("\t%v: %v\n", name, value)
+				diagnose(ctxt, sevWarning, "attribute %s=\"%s\" ignored", name, value)
 			}
 		}
 
 		// fmt.Printf("xml element %v: %v\n", elem.name, elem.etype)
+		var setType func(xml.Name)
+		var getAnn func() *annotations
 		if ctxt.cplxType == nil {
-			ctxt.root = elem
+			elem.ns = ctxt.scope.targetNs // global elements are always qualified
+			qn := xml.Name{Space: elem.ns, Local: elem.name}
+			ctxt.elements[qn] = elem
+			ctxt.positions[typeNode{element: true, name: qn}] = currentPosition(ctxt)
+			// included files only supply a root if the main file has none
+			if len(ctxt.fileStack) == 1 || ctxt.root.Local == "" {
+				ctxt.root = qn
+			}
+			setType = func(qn xml.Name) { elem.etype = qn }
+			getAnn = func() *annotations { return &elem.ann }
 		} else {
-			found := false
+			if elem.ref.Local != "" {
+				elem.ns = elem.ref.Space
+			} else {
+				elem.ns = formNamespace(attrs["form"], ctxt.scope.elemQualified, ctxt)
+			}
+			idx := -1
 			// over-write if already exists
 			for i, old := range ctxt.cplxType.elems {
 				if old.name == elem.name {
 					ctxt.cplxType.elems[i] = *elem
-					found = true
+					idx = i
 				}
 			}
-			if !found {
+			if idx < 0 {
 				ctxt.cplxType.elems = append(ctxt.cplxType.elems, *elem)
+				idx = len(ctxt.cplxType.elems) - 1
+			}
+			if n := len(ctxt.compStack); n > 0 {
+				top := ctxt.compStack[n-1]
+				top.parts = append(top.parts, particle{elem: elem.name})
 			}
+			cplx := ctxt.cplxType
+			setType = func(qn xml.Name) { cplx.elems[idx].etype = qn }
+			getAnn = func() *annotations { return &cplx.elems[idx].ann }
 		}
+		pushOwner(elem.name, setType, getAnn, ctxt)
 	case "attribute":
 		attr := attribute{}
 		for name, value := range attrs {
 			switch name {
 			case "name":
 				attr.name = value
+			case "ref": // the type is filled in once everything is parsed
+				attr.ref = resolveQName(value, ctxt)
 			case "type":
-				attr.atype = value
+				attr.atype = resolveQName(value, ctxt)
 			case "default":
 				attr.adefault = value
 			case "fixed":
 				attr.fixed = value
 			case "use":
 				attr.required = (value == "required")
+				attr.prohibited = (value == "prohibited")
 			}
 		}
+		attr.ns = formNamespace(attrs["form"], ctxt.scope.attrQualified, ctxt)
+		if attr.ref.Local != "" { // global attributes are always qualified
+			attr.name, attr.ns = attrRefName(attr.ref), attr.ref.Space
+		}
+		var setType func(xml.Name)
+		var getAnn func() *annotations
 		if ctxt.smplType != nil {
 			ctxt.smplType.attrs = append(ctxt.smplType.attrs, attr)
-		} else {
+			smpl, idx := ctxt.smplType, len(ctxt.smplType.attrs)-1
+			setType = func(qn xml.Name) { smpl.attrs[idx].atype = qn }
+			getAnn = func() *annotations { return &smpl.attrs[idx].ann }
+		} else if ctxt.cplxType != nil {
 			ctxt.cplxType.attrs = append(ctxt.cplxType.attrs, attr)
+			cplx, idx := ctxt.cplxType, len(ctxt.cplxType.attrs)-1
+			setType = func(qn xml.Name) { cplx.attrs[idx].atype = qn }
+			getAnn = func() *annotations { return &cplx.attrs[idx].ann }
+		} else {
+			// a global attribute, for references to it
+			global := &attr
+			global.ns = ctxt.scope.targetNs
+			ctxt.attributes[xml.Name{Space: global.ns, Local: global.name}] = global
+			setType = func(qn xml.Name) { global.atype = qn }
+			getAnn = func() *annotations { return &global.ann }
 		}
-	case "sequence": // sequence and choice can also occur in extensions!
+		pushOwner("@"+attr.name, setType, getAnn, ctxt)
+	case "sequence", "all": // sequence and choice can also occur in extensions!
 		fallthrough
 	case "choice":
-		ctxt.cplxType.etype = el.Name.Local
+		comp := newCompositor(el.Name.Local)
+		if value, ok := attrs["minOccurs"]; ok {
+			comp.minOccurs = parseOccurs(value)
+		}
+		if value, ok := attrs["maxOccurs"]; ok {
+			comp.maxOccurs = parseOccurs(value)
+		}
+		pushCompositor(comp, ctxt)
+	case "group":
+		if ref, ok := attrs["ref"]; ok {
+			comp := newCompositor("group")
+			comp.ref = resolveQName(ref, ctxt)
+			if selfReference("group", comp.ref, ctxt.cplxType.name, ctxt) {
+				comp.ref = originalName(comp.ref)
+			}
+			if value, ok := attrs["minOccurs"]; ok {
+				comp.minOccurs = parseOccurs(value)
+			}
+			if value, ok := attrs["maxOccurs"]; ok {
+				comp.maxOccurs = parseOccurs(value)
+			}
+			pushCompositor(comp, ctxt)
+			pushOwner("", nil, nil, ctxt)
+		} else {
+			// parse the definition as if it were a complex type
+			qn := pushType(attrs["name"], ctxt)
+			ctxt.cplxType = newComplexType(qn)
+		}
+	case "attributeGroup":
+		if ref, ok := attrs["ref"]; ok {
+			qn := resolveQName(ref, ctxt)
+			if ctxt.smplType != nil {
+				ctxt.smplType.attrGroups = append(ctxt.smplType.attrGroups, qn)
+			} else {
+				if selfReference("attributeGroup", qn, ctxt.cplxType.name, ctxt) {
+					qn = originalName(qn)
+				}
+				ctxt.cplxType.attrGroups = append(ctxt.cplxType.attrGroups, qn)
+			}
+			pushOwner("", nil, nil, ctxt)
+		} else {
+			qn := pushType(attrs["name"], ctxt)
+			ctxt.cplxType = newComplexType(qn)
+		}
 	case "restriction": // mandatory base attribute
 		fallthrough
 	case "extension":
-		baseName := attrs["base"]
+		baseName := resolveQName(attrs["base"], ctxt)
 		if ctxt.smplType != nil { // we're doing a simple type
-			ctxt.smplType.base = baseName
+			if selfReference("type", baseName, ctxt.smplType.name, ctxt) {
+				// a redefinition adds its facets to those of the original
+				if original, ok := ctxt.simpleTypes[baseName]; ok {
+					ann := ctxt.smplType.ann
+					ctxt.smplType = original.clone(&baseName)
+					ctxt.smplType.ann = ann
+					baseName = ctxt.smplType.base
+				}
+			}
+			// the base may instead be given by an anonymous simpleType child
+			smpl := ctxt.smplType
+			smpl.base = baseName
+			pushOwner("", func(qn xml.Name) { smpl.base = qn }, nil, ctxt)
 		} else {
+			pushOwner("", nil, nil, ctxt)
+			// the original of a redefined type is still in place until it ends
+			selfReference("type", baseName, ctxt.cplxType.name, ctxt)
 			simpleBase, isSimple := ctxt.simpleTypes[baseName]
-			complexBase, isComplex := ctxt.complexTypes[baseName]
+			_, isComplex := ctxt.complexTypes[baseName]
+			parent := ctxt.xsdStack[len(ctxt.xsdStack)-1]
 			switch {
 			case isSimple:
 				// We are going to change this to a simple type
 				smplName := ctxt.cplxType.name
+				ann := ctxt.cplxType.ann
 				ctxt.cplxType = nil
 				ctxt.smplType = simpleBase.clone(&smplName)
+				ctxt.smplType.ann = ann
 				//				ctxt.cplxType.simpleBase = &simpleBase
-			case isComplex:
-				// deep copy of the base type, then we can over-write / add to elements
-				ctxt.cplxType = complexBase.clone(&ctxt.cplxType.name)
+			case parent == "complexContent" || isComplex:
+				// only what the derivation declares is kept; it is merged
+				// with its base by resolveDerivations, as the base may
+				// not have been parsed yet
+				ctxt.cplxType.derivation = el.Name.Local
+				ctxt.cplxType.base = baseName
+			case isBuiltin(baseName) && parent == "simpleContent":
+				// a builtin with attributes
+				cplx := ctxt.cplxType
+				ctxt.cplxType = nil
+				ctxt.smplType = newSimpleType(cplx.name)
+				ctxt.smplType.base = baseName
+				ctxt.smplType.attrs = cplx.attrs
+				ctxt.smplType.attrGroups = cplx.attrGroups
+				ctxt.smplType.ann = cplx.ann
+			case isBuiltin(baseName) && baseName.Local == "anyType":
+			case parent == "simpleContent":
+				// a simple base not parsed yet: the facets and attributes
+				// are kept, and added to a copy of the base by resolveDerivations
+				cplx := ctxt.cplxType
+				ctxt.cplxType = nil
+				ctxt.smplType = newSimpleType(cplx.name)
+				ctxt.smplType.contentBase = baseName
+				ctxt.smplType.attrs = cplx.attrs
+				ctxt.smplType.attrGroups = cplx.attrGroups
+				ctxt.smplType.ann = cplx.ann
 			default:
-				fmt.Printf("Whoops! Complex %s no base type %s found\n", ctxt.cplxType.name, baseName)
+				diagnose(ctxt, sevError, "base type %s not found", attrs["base"])
 			}
 		}
+		// the patterns of each derivation step are kept apart
+		if ctxt.smplType != nil {
+			ctxt.smplType.patterns = append(ctxt.smplType.patterns, []string{})
+		}
+	case "list": // item type given by itemType or an anonymous simpleType
+		smpl := ctxt.smplType
+		smpl.variety = "list"
+		if itemType, ok := attrs["itemType"]; ok {
+			smpl.itemType = resolveQName(itemType, ctxt)
+		}
+		pushOwner("item", func(qn xml.Name) { smpl.itemType = qn }, nil, ctxt)
+	case "union": // members given by memberTypes and/or anonymous simpleTypes
+		smpl := ctxt.smplType
+		smpl.variety = "union"
+		for _, member := range strings.Fields(attrs["memberTypes"]) {
+			smpl.memberTypes = append(smpl.memberTypes, resolveQName(member, ctxt))
+		}
+		pushOwner("member", func(qn xml.Name) { smpl.memberTypes = append(smpl.memberTypes, qn) }, nil, ctxt)
 	case "enumeration": // always nested within a simpleType
-		ctxt.smplType.enum = append(ctxt.smplType.enum, el.Attr[0].Value)
-	case "minInclusive":
-		ctxt.smplType.minInclusive, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "maxInclusive":
-		ctxt.smplType.maxInclusive, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "minExclusive":
-		ctxt.smplType.minExclusive, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "maxExclusive":
-		ctxt.smplType.maxExclusive, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "totalDigits":
-		ctxt.smplType.totalDigits, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "fractionDigits":
-		ctxt.smplType.fractionDigits, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "length":
-		ctxt.smplType.length, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "minLength":
-		ctxt.smplType.minLength, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "maxLength":
-		ctxt.smplType.maxLength, _ = strconv.ParseInt(el.Attr[0].Value, 10, 64)
-	case "whitespace":
-		ctxt.smplType.whiteSpace = el.Attr[0].Value
-	case "pattern":
-		ctxt.smplType.pattern = el.Attr[0].Value
+		smpl := ctxt.smplType
+		if smpl == nil {
+			diagnose(ctxt, sevError, "enumeration outside a simple type ignored")
+			pushOwner("", nil, nil, ctxt)
+			break
+		}
+		smpl.enum = append(smpl.enum, attrs["value"])
+		smpl.enumAnns = append(smpl.enumAnns, annotations{})
+		idx := len(smpl.enumAnns) - 1
+		pushOwner("", nil, func() *annotations { return &smpl.enumAnns[idx] }, ctxt)
+	case "minInclusive", "maxInclusive", "minExclusive", "maxExclusive", "totalDigits", "fractionDigits",
+		"length", "minLength", "maxLength", "whiteSpace", "pattern":
+		if ctxt.smplType == nil {
+			diagnose(ctxt, sevError, "%s outside a simple type ignored", el.Name.Local)
+			break
+		}
+		setFacet(el.Name.Local, attrs["value"], ctxt.smplType)
+	case "assert", "assertion": // XSD 1.1, translated once everything is parsed
+		a := assertion{test: attrs["test"]}
+		if ctxt.smplType != nil {
+			ctxt.smplType.asserts = append(ctxt.smplType.asserts, a)
+		} else {
+			ctxt.cplxType.asserts = append(ctxt.cplxType.asserts, a)
+		}
 	case "simpleType":
-		ctxt.smplType = newSimpleType(el.Attr[0].Value)
+		qn := pushType(attrs["name"], ctxt)
+		ctxt.smplType = newSimpleType(qn)
 	case "complexType":
-		ctxt.cplxType = newComplexType(el.Attr[0].Value)
+		qn := pushType(attrs["name"], ctxt)
+		ctxt.cplxType = newComplexType(qn)
+		ctxt.cplxType.abstract = attrs["abstract"] == "true"
+		ctxt.cplxType.final = attrs["final"]
+		ctxt.cplxType.mixed = attrs["mixed"] == "true"
+	case "complexContent": // holder for extension or restriction
+		if mixed, ok := attrs["mixed"]; ok {
+			ctxt.cplxType.mixed = mixed == "true"
+		}
 	case "simpleContent": // holder for extension or restriction
 		break
-	case "any":
+	case "any": // a particle, also listed for the properties it opens
+		w := newWildcard(attrs, ctxt)
 		ctxt.cplxType.anyFlag = true
-	case "schema": // do nothing
-	default:
-		fmt.Printf("startElement: %v\n", el.Name.Local)
-		for _, attr := range el.Attr {
-			fmt.Printf("\t%v: %v\n", attr.Name.Local, attr.Value)
+		ctxt.cplxType.anyElems = append(ctxt.cplxType.anyElems, w)
+		if n := len(ctxt.compStack); n > 0 {
+			top := ctxt.compStack[n-1]
+			top.parts = append(top.parts, particle{wild: &w})
 		}
+	case "anyAttribute":
+		w := newWildcard(attrs, ctxt)
+		if ctxt.smplType != nil {
+			ctxt.smplType.anyAttr = &w
+		} else {
+			ctxt.cplxType.anyAttr = &w
+		}
+	case "annotation":
+		startAnnotation(ctxt)
+	case "documentation", "appinfo":
+		startAnnotationContent(el.Name.Local, ctxt)
+	case "include":
+		includeSchema(attrs["schemaLocation"], "", false, ctxt)
+	case "redefine", "override": // the included components are replaced as their redefinitions end
+		includeSchema(attrs["schemaLocation"], "", false, ctxt)
+	case "import":
+		includeSchema(attrs["schemaLocation"], attrs["namespace"], true, ctxt)
+	case "schema":
+		if ns, ok := attrs["targetNamespace"]; ok {
+			ctxt.scope.targetNs = ns
+		} else {
+			ctxt.scope.targetNs = ctxt.scope.chameleonNs
+		}
+		ctxt.scope.elemQualified = attrs["elementFormDefault"] == "qualified"
+		ctxt.scope.attrQualified = attrs["attributeFormDefault"] == "qualified"
+		if len(ctxt.fileStack) == 1 {
+			ctxt.mainNs = ctxt.scope.targetNs
+		}
+	default:
+		diagnose(ctxt, sevWarning, "unsupported %s ignored", el.Name.Local)
 	}
 }
 
 func endElement(el *xml.EndElement, ctxt *context) {
 	switch el.Name.Local {
 	//all the above do nothing
-	case "enumeration":
-	case "choice":
-	case "sequence":
-	case "restriction":
+	case "annotation":
 	case "minInclusive":
 	case "maxInclusive":
 	case "minExclusive":
@@ -211,30 +687,64 @@ func endElement(el *xml.EndElement, ctxt *context) {
 	case "length":
 	case "minLength":
 	case "maxLength":
-	case "whitespace":
+	case "whiteSpace":
 	case "pattern":
-	case "simpleContent":
-	case "attribute":
-	case "extension":
-	case "any":
+	case "simpleContent", "complexContent":
+	case "any", "anyAttribute":
+	case "assert", "assertion":
+	case "include", "redefine", "override":
+	case "import":
 	case "schema":
 		//all the above do nothing
 	case "element":
 		ctxt.elem = nil // force an error if assignment attempted
+		popFrame(ctxt)
+	case "attribute", "restriction", "extension", "list", "union", "enumeration":
+		popFrame(ctxt)
+	case "sequence", "choice", "all":
+		popCompositor(ctxt)
+	case "group":
+		if ctxt.frames[len(ctxt.frames)-1].isType { // definition
+			if endRedefinition("group", ctxt.cplxType.name, ctxt) {
+				ctxt.groups[ctxt.cplxType.name] = *ctxt.cplxType
+			}
+			ctxt.cplxType = nil
+			popType(ctxt)
+		} else { // reference
+			popFrame(ctxt)
+			popCompositor(ctxt)
+		}
+	case "attributeGroup":
+		if ctxt.frames[len(ctxt.frames)-1].isType { // definition
+			if endRedefinition("attributeGroup", ctxt.cplxType.name, ctxt) {
+				ctxt.attrGroups[ctxt.cplxType.name] = *ctxt.cplxType
+			}
+			ctxt.cplxType = nil
+			popType(ctxt)
+		} else { // reference
+			popFrame(ctxt)
+		}
 	case "simpleType":
-		ctxt.simpleTypes[ctxt.smplType.name] = *ctxt.smplType
+		if endRedefinition("simpleType", ctxt.smplType.name, ctxt) {
+			ctxt.simpleTypes[ctxt.smplType.name] = *ctxt.smplType
+		}
 		// fmt.Printf("simpleType %+v", ctxt.smplType)
 		ctxt.smplType = nil // force an error if assignment attempted
+		popType(ctxt)
 	case "complexType":
 		if ctxt.smplType != nil {
-			ctxt.simpleTypes[ctxt.smplType.name] = *ctxt.smplType
+			if endRedefinition("complexType", ctxt.smplType.name, ctxt) {
+				ctxt.simpleTypes[ctxt.smplType.name] = *ctxt.smplType
+			}
 			ctxt.smplType = nil // force an error if assignment attempted
 		} else {
-			ctxt.complexTypes[ctxt.cplxType.name] = *ctxt.cplxType
+			if endRedefinition("complexType", ctxt.cplxType.name, ctxt) {
+				ctxt.complexTypes[ctxt.cplxType.name] = *ctxt.cplxType
+			}
 			// fmt.Printf("complexType %+v", ctxt.cplxType)
 			ctxt.cplxType = nil // force an error if assignment attempted
 		}
-	default:
-		fmt.Printf("Unclassified endElement: %v\n", el.Name.Local)
+		popType(ctxt)
+	default: // unsupported, reported at the start
 	}
 }
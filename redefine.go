@@ -0,0 +1,131 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// redefine
+// xs:redefine and xs:override: the included schema is parsed first, then
+// the components within replace those of the same name
+
+package main
+
+import (
+	"encoding/xml"
+)
+
+// the xs:redefine or xs:override holding the component being parsed, if
+// any, and the kind of component; only top level ones can be redefined
+func redefinition(ctxt *context) (string, string) {
+	for i := len(ctxt.xsdStack) - 1; i > 0; i-- {
+		switch kind := ctxt.xsdStack[i]; kind {
+		case "simpleType", "complexType", "group", "attributeGroup":
+			if parent := ctxt.xsdStack[i-1]; parent == "redefine" || parent == "override" {
+				return parent, kind
+			}
+			return "", kind
+		}
+	}
+	return "", ""
+}
+
+// the name the original of a redefined group is kept under
+// the "/" keeps it apart from named groups
+func originalName(qn xml.Name) xml.Name {
+	return xml.Name{Space: qn.Space, Local: qn.Local + "/original"}
+}
+
+// is a base or group reference to the component being redefined?
+// if so it refers to the original, which xs:override does not allow
+// types share their names, so kind is "type" for either
+func selfReference(kind string, qn xml.Name, own xml.Name, ctxt *context) bool {
+	parent, ownKind := redefinition(ctxt)
+	if ownKind == "simpleType" || ownKind == "complexType" {
+		ownKind = "type"
+	}
+	if qn != own || kind != ownKind {
+		return false
+	}
+	switch parent {
+	case "redefine":
+		ctxt.selfDerived = true
+		return true
+	case "override":
+		diagnose(ctxt, sevError, "%s refers to itself", qn.Local)
+	}
+	return false
+}
+
+// end a component of an xs:redefine or xs:override, before it replaces
+// the original; the original must have been in the included schema, and
+// a redefinition must derive from it (a group, by referring to itself or
+// restricting it)
+// returns false if an override has nothing to replace, so is dropped
+func endRedefinition(kind string, qn xml.Name, ctxt *context) bool {
+	parent := ctxt.xsdStack[len(ctxt.xsdStack)-1]
+	if parent != "redefine" && parent != "override" {
+		return true
+	}
+	selfDerived := ctxt.selfDerived
+	ctxt.selfDerived = false
+
+	var found bool
+	switch kind {
+	case "group":
+		var original complexType
+		if original, found = ctxt.groups[qn]; found && parent == "redefine" {
+			ctxt.groups[originalName(qn)] = original
+			if !selfDerived {
+				restricted := *ctxt.cplxType
+				restricted.derivation = "restriction"
+				checkRestriction(restricted, original, ctxt)
+			}
+		}
+	case "attributeGroup":
+		var original complexType
+		if original, found = ctxt.attrGroups[qn]; found && parent == "redefine" {
+			ctxt.attrGroups[originalName(qn)] = original
+			for _, attr := range ctxt.cplxType.attrs {
+				if _, ok := findAttr(original.attrs, attr.name); !ok && !selfDerived {
+					diagnose(ctxt, sevError, "redefined attributeGroup %s adds attribute %s", qn.Local, attr.name)
+				}
+			}
+		}
+	default:
+		var original complexType
+		_, isSimple := ctxt.simpleTypes[qn]
+		original, found = ctxt.complexTypes[qn]
+		if found {
+			ctxt.originals[qn] = original
+		}
+		found = found || isSimple
+		// the kind may change, e.g. to a complexType with simpleContent
+		if ctxt.smplType != nil {
+			delete(ctxt.complexTypes, qn)
+		} else {
+			delete(ctxt.simpleTypes, qn)
+		}
+		if found && parent == "redefine" && !selfDerived {
+			diagnose(ctxt, sevError, "redefined %s %s must derive from the original", kind, qn.Local)
+		}
+	}
+	switch {
+	case found:
+	case parent == "redefine":
+		diagnose(ctxt, sevError, "redefined %s %s not in the redefined schema", kind, qn.Local)
+	default:
+		diagnose(ctxt, sevWarning, "overridden %s %s not in the overridden schema, ignored", kind, qn.Local)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// roots
+// choose the global elements the JSON schema describes, and write them
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// choose the roots: those named by -root, every global element for "*",
+// or by default the last global element of the main file
+func selectRoots(ctxt *context) {
+	switch {
+	case len(ctxt.rootNames) == 0:
+		if ctxt.root.Local == "" {
+			diagnoseComponent(ctxt, sevWarning, typeNode{name: xml.Name{Local: ctxt.inFileBase}}, "no global element to use as the root, only definitions written")
+			return
+		}
+		ctxt.roots = []xml.Name{ctxt.root}
+	case len(ctxt.rootNames) == 1 && ctxt.rootNames[0] == "*":
+		for qn, el := range ctxt.elements {
+			if !el.abstract {
+				ctxt.roots = append(ctxt.roots, qn)
+			}
+		}
+		sortNames(ctxt.roots)
+	default:
+		for _, name := range ctxt.rootNames {
+			if qn, ok := findRoot(name, ctxt); ok {
+				ctxt.roots = append(ctxt.roots, qn)
+			}
+		}
+	}
+}
+
+// the global element named by -root, as Name or prefix:Name
+// a bare name in the main namespace wins over the same name in others
+func findRoot(name string, ctxt *context) (xml.Name, bool) {
+	prefix, local := "", name
+	if idx := strings.Index(name, ":"); idx > -1 {
+		prefix, local = name[:idx], name[idx+1:]
+	}
+	matches := make([]xml.Name, 0)
+	for qn := range ctxt.elements {
+		switch {
+		case qn.Local != local:
+		case prefix != "" && ctxt.nsPrefixes[qn.Space] != prefix:
+		case prefix == "" && qn.Space == ctxt.mainNs:
+			return qn, true
+		default:
+			matches = append(matches, qn)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		diagnoseComponent(ctxt, sevError, typeNode{element: true, name: xml.Name{Local: name}}, "root element not found among the global elements")
+		return xml.Name{}, false
+	case 1:
+		return matches[0], true
+	}
+	diagnoseComponent(ctxt, sevError, typeNode{element: true, name: xml.Name{Local: name}}, "root element is in several namespaces, give its prefix")
+	return xml.Name{}, false
+}
+
+// write the roots at the top of the schema
+// a single root describes the content of the element, as the element
+// itself is implied; several are each wrapped in an object naming them:
+// "oneOf": [
+// {"type": "object", "properties": {"Document": {"$ref": ...}}, "required": ["Document"], ...},
+// ],
+func writeRoots(f io.Writer, ctxt *context, indent int) {
+	switch len(ctxt.roots) {
+	case 0:
+		return
+	case 1:
+		el := ctxt.elements[ctxt.roots[0]]
+		if rootType, found := ctxt.complexTypes[el.etype]; found {
+			rootType.ann = annotations{} // the headers already give a description
+			writeComplexBody(rootType, f, ctxt, indent)
+		} else {
+			writeElementBody(*el, f, ctxt, indent)
+		}
+		return
+	}
+	inPrintf(f, indent, "\"oneOf\": [\n")
+	for _, qn := range ctxt.roots {
+		inPrintf(f, indent+tsz, "{\n")
+		inPrintf(f, indent+tsz+tsz, "\"type\": \"object\",\n")
+		inPrintf(f, indent+tsz+tsz, "\"properties\": {\"%s\": {\"$ref\": \"#/definitions/%s\"}},\n", qn.Local, elemDefName(qn, ctxt))
+		inPrintf(f, indent+tsz+tsz, "\"required\": [\"%s\"],\n", qn.Local)
+		inPrintf(f, indent+tsz+tsz, "\"additionalProperties\": false,\n")
+		inPrintf(f, indent+tsz, "},\n")
+	}
+	inPrintf(f, indent, "],\n")
+}
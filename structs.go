@@ -19,6 +19,10 @@
 
 package main
 
+import (
+	"encoding/xml"
+)
+
 // anything that has a name
 type named interface {
 	getName() string
@@ -31,72 +35,166 @@ type attributed interface {
 
 // any element
 type element struct {
-	name      string
-	etype     string
-	minOccurs int64
-	maxOccurs int64
+	name        string
+	ns          string   // namespace, empty if unqualified
+	ref         xml.Name // the global element referred to, if any
+	etype       xml.Name
+	abstract    bool
+	substGroups []xml.Name // heads of the substitution groups it belongs to
+	edefault    string
+	fixed       string
+	nillable    bool // xsi:nil="true" allowed, so may be null
+	ann         annotations
+	minOccurs   int64
+	maxOccurs   int64
 }
 
 // any attribute
 type attribute struct {
 	name     string
-	atype    string
+	ns       string   // namespace, empty if unqualified
+	ref      xml.Name // the global attribute referred to, if any
+	atype    xml.Name
 	adefault string
 	fixed    string
 	required bool
+	// use="prohibited", which removes an attribute of the base of a restriction
+	prohibited bool
+	ann        annotations
 }
 
 // definition of a simple type
 type simpleType struct {
-	name string
+	name        xml.Name
+	variety     string     // atomic (restriction) | list | union
+	itemType    xml.Name   // list
+	memberTypes []xml.Name // union
 	// restrictions
-	base           string
+	base           xml.Name
 	attrs          []attribute
+	attrGroups     []xml.Name // attributeGroup references, expanded after parsing
 	enum           []string
-	minExclusive   int64
-	minInclusive   int64
-	maxExclusive   int64
-	maxInclusive   int64
+	enumAnns       []annotations // one per enum value
+	minExclusive   bound
+	minInclusive   bound
+	maxExclusive   bound
+	maxInclusive   bound
 	totalDigits    int64
 	fractionDigits int64
 	length         int64
 	minLength      int64
 	maxLength      int64
-	whiteSpace     string // preserve | replace | collapse
-	pattern        string
+	whiteSpace     string     // preserve | replace | collapse
+	patterns       [][]string // per derivation step: ORed within a step, ANDed across steps
+	anyAttr        *wildcard
+	asserts        []assertion // XSD 1.1 xs:assertion facets
+	ann            annotations
+	contentBase    xml.Name // simple content base declared later, merged by resolveDerivations
+}
+
+// a compositor and the particles it contains
+type compositor struct {
+	ctype     string   // sequence | choice | all (any order) | group (reference)
+	ref       xml.Name // the group referred to
+	minOccurs int64
+	maxOccurs int64
+	parts     []particle
+}
+
+// an element or nested compositor within a content model
+type particle struct {
+	elem string      // element name, if an element
+	comp *compositor // or the nested compositor
+	wild *wildcard   // or an xs:any
 }
 
 // definition of a complex type
 type complexType struct {
-	name       string
+	name       xml.Name
 	attrs      []attribute
-	etype      string // sequence | choice
-	elems      []element
+	attrGroups []xml.Name // attributeGroup references, expanded after parsing
+	derivation string     // restriction | extension of a complex base
+	base       xml.Name   // the complex base
+	abstract   bool
+	final      string // derivations the type may not be used as a base for
+	mixed      bool   // text may appear between the child elements
+	ann        annotations
+	content    *compositor // the outermost compositor
+	elems      []element   // every element in content, in document order
 	simpleBase *simpleType
 	anyFlag    bool //does the type allow "any" extension?
+	anyElems   []wildcard
+	anyAttr    *wildcard
+	asserts    []assertion // XSD 1.1 xs:assert
 }
 
 // data being worked on
 type context struct {
-	inFile      string
-	outFile     string
-	inFileBase  string // base part of path
-	outFileBase string
-	domain      string
-	smplType    *simpleType
-	cplxType    *complexType
-	elem        *element
+	inFile       string
+	outFile      string
+	inFileBase   string // base part of path
+	outFileBase  string
+	domain       string
+	catFile      string   // optional XML catalog
+	catalog      *catalog // loaded from catFile
+	fileStack    []string // absolute paths of the files being parsed (innermost last)
+	loaded       map[string]bool
+	scope        schemaScope       // namespace state of the file being parsed
+	mainNs       string            // targetNamespace of the main file
+	nsPrefixes   map[string]string // namespace -> first prefix declared for it
+	defNames     map[xml.Name]string
+	elemDefNames map[xml.Name]string
+	anonInline   bool         // write anonymous types inline rather than as definitions
+	listArrays   bool         // write xs:list types as arrays rather than strings
+	mixedOrdered bool         // write mixed content as an ordered #mixed array
+	draft        int          // JSON schema draft to write: 4, 6 or 7
+	digitsString bool         // write numbers with totalDigits / fractionDigits as strings
+	frames       []parseFrame // components being parsed (innermost last)
+	pathStack    []string     // path to the XSD element being parsed, for diagnostics
+	line         int          // position of the XSD element being parsed
+	column       int
+	diags        []diagnostic  // problems found so far
+	compStack    []*compositor // open compositors of the complex type being parsed
+	xsdStack     []string      // names of the open schema elements
+	annot        annotationState
+	smplType     *simpleType
+	cplxType     *complexType
+	elem         *element
 	// the dictionary
-	root         *element
-	simpleTypes  map[string]simpleType
-	complexTypes map[string]complexType
+	root         xml.Name                // the last global element of the main file, the default root
+	rootNames    []string                // global elements chosen by -root, "*" for all
+	roots        []xml.Name              // the global elements written at the top of the schema
+	elements     map[xml.Name]*element   // global elements
+	attributes   map[xml.Name]*attribute // global attributes
+	simpleTypes  map[xml.Name]simpleType
+	complexTypes map[xml.Name]complexType
+	groups       map[xml.Name]complexType // model groups: content and elems only
+	attrGroups   map[xml.Name]complexType // attribute groups: attrs and attrGroups only
+	originals    map[xml.Name]complexType // complex types replaced by xs:redefine or xs:override
+	selfDerived  bool                     // the component being redefined refers to its original
+	cycles       cycleInfo                // types and elements that contain themselves
+	positions    map[typeNode]position    // where each type and global element is declared
 }
 
 // initialise the context
 func newContext() context {
 	c := context{}
-	c.simpleTypes = make(map[string]simpleType)
-	c.complexTypes = make(map[string]complexType)
+	c.simpleTypes = make(map[xml.Name]simpleType)
+	c.complexTypes = make(map[xml.Name]complexType)
+	c.groups = make(map[xml.Name]complexType)
+	c.attrGroups = make(map[xml.Name]complexType)
+	c.originals = make(map[xml.Name]complexType)
+	c.nsPrefixes = make(map[string]string)
+	c.defNames = make(map[xml.Name]string)
+	c.elemDefNames = make(map[xml.Name]string)
+	c.elements = make(map[xml.Name]*element)
+	c.attributes = make(map[xml.Name]*attribute)
+	c.positions = make(map[typeNode]position)
+	c.frames = make([]parseFrame, 0)
+	c.compStack = make([]*compositor, 0)
+	c.xsdStack = make([]string, 0)
+	c.fileStack = make([]string, 0)
+	c.loaded = make(map[string]bool)
 	return c
 }
 
@@ -105,10 +203,10 @@ func (e element) getName() string {
 	return e.name
 }
 func (s simpleType) getName() string {
-	return s.name
+	return s.name.Local
 }
 func (c complexType) getName() string {
-	return c.name
+	return c.name.Local
 }
 func (s simpleType) getAttrs() []attribute {
 	return s.attrs
@@ -121,39 +219,39 @@ func (c complexType) getAttrs() []attribute {
 func newElement() *element {
 	return &element{
 		name:      "",
-		etype:     "",
+		etype:     xml.Name{},
 		minOccurs: -1,
 		maxOccurs: -1,
 	}
 }
 
 // create a new simple type
-func newSimpleType(aname string) *simpleType {
+func newSimpleType(aname xml.Name) *simpleType {
 	return &simpleType{
 		name:           aname,
-		base:           "",
+		variety:        "atomic",
+		itemType:       xml.Name{},
+		memberTypes:    make([]xml.Name, 0),
+		base:           xml.Name{},
 		attrs:          make([]attribute, 0),
 		enum:           make([]string, 0),
-		minExclusive:   -1,
-		minInclusive:   -1,
-		maxExclusive:   -1,
-		maxInclusive:   -1,
+		enumAnns:       make([]annotations, 0),
 		totalDigits:    -1,
 		fractionDigits: -1,
 		length:         -1,
 		minLength:      -1,
 		maxLength:      -1,
 		whiteSpace:     "",
-		pattern:        "",
 	}
 }
 
 // create a new complex type
-func newComplexType(aname string) *complexType {
+func newComplexType(aname xml.Name) *complexType {
 	return &complexType{
 		name:       aname,
 		attrs:      make([]attribute, 0),
-		etype:      "",
+		derivation: "",
+		content:    nil,
 		elems:      make([]element, 0),
 		simpleBase: nil,
 	}
@@ -162,26 +260,30 @@ func newComplexType(aname string) *complexType {
 // create a clone of simpleType with a new name if specified
 // Deep copy attrs & enum so they can be
 // mutated without affecting the original.
-func (s *simpleType) clone(name *string) *simpleType {
+func (s *simpleType) clone(name *xml.Name) *simpleType {
 	n := s
 	if name != nil {
 		n.name = *name
 	}
 	n.attrs = append(make([]attribute, 0), s.attrs...)
+	n.attrGroups = append(make([]xml.Name, 0), s.attrGroups...)
 	n.enum = append(make([]string, 0), s.enum...)
+	n.enumAnns = append(make([]annotations, 0), s.enumAnns...)
+	n.memberTypes = append(make([]xml.Name, 0), s.memberTypes...)
+	n.patterns = append(make([][]string, 0), s.patterns...)
+	n.asserts = append(make([]assertion, 0), s.asserts...)
 	return n
 }
 
 // create a clone of complexType with a new name if specified
-// Deep copy attrs & elems so they can be
+// Deep copy attrs, elems & content so they can be
 // mutated without affecting the original.
-func (c *complexType) clone(name *string) *complexType {
+func (c *complexType) clone(name *xml.Name) *complexType {
 	// n := newComplexType(c.name)
 	// if name != nil {
 	// 	n.name = *name
 	// }
 	// n.attrs = append(n.attrs, c.attrs...)
-	// n.etype = c.etype
 	// n.elems = append(n.elems, c.elems...)
 	// n.simpleBase = c.simpleBase
 	n := c
@@ -189,6 +291,42 @@ func (c *complexType) clone(name *string) *complexType {
 		n.name = *name
 	}
 	n.attrs = append(make([]attribute, 0), c.attrs...)
+	n.attrGroups = append(make([]xml.Name, 0), c.attrGroups...)
 	n.elems = append(make([]element, 0), c.elems...)
+	n.content = c.content.clone()
+	n.asserts = append(make([]assertion, 0), c.asserts...)
 	return n
 }
+
+// create a new compositor
+func newCompositor(ctype string) *compositor {
+	return &compositor{
+		ctype:     ctype,
+		minOccurs: -1,
+		maxOccurs: -1,
+		parts:     make([]particle, 0),
+	}
+}
+
+// deep copy a compositor tree
+func (c *compositor) clone() *compositor {
+	if c == nil {
+		return nil
+	}
+	n := *c
+	n.parts = make([]particle, len(c.parts))
+	for i, p := range c.parts {
+		n.parts[i] = particle{elem: p.elem, comp: p.comp.clone(), wild: p.wild}
+	}
+	return &n
+}
+
+// find an element by name
+func (c *complexType) findElem(name string) (element, bool) {
+	for _, el := range c.elems {
+		if el.name == name {
+			return el, true
+		}
+	}
+	return element{}, false
+}
@@ -0,0 +1,184 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// values
+// default and fixed values of elements and attributes, typed as JSON
+
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// the JSON type of the values of a type: number, boolean, string or object
+// user simple types take the type of the builtin they are derived from
+func valueType(qn xml.Name, ctxt *context) string {
+	for depth := 0; depth < 100; depth++ {
+		if isBuiltin(qn) {
+			jtype, _ := mapTypename(qn)
+			if _, isList := xlistItems[qn.Local]; isList || (jtype != "number" && jtype != "boolean") {
+				return "string"
+			}
+			return jtype
+		}
+		simple, ok := ctxt.simpleTypes[qn]
+		if !ok {
+			if _, ok := ctxt.complexTypes[qn]; ok {
+				return "object"
+			}
+			return "string"
+		}
+		if simple.variety == "list" || simple.variety == "union" || digitsAsString(simple, ctxt) {
+			return "string"
+		}
+		qn = simple.base
+	}
+	return "string"
+}
+
+// the whiteSpace facet in force for a type, inherited from its base
+// unless it sets its own; a list always collapses, a union has none
+func whiteSpace(qn xml.Name, ctxt *context) string {
+	for depth := 0; depth < 100; depth++ {
+		if isBuiltin(qn) {
+			return builtinWhiteSpace(qn)
+		}
+		simple, ok := ctxt.simpleTypes[qn]
+		switch {
+		case !ok || simple.variety == "union":
+			return ""
+		case simple.whiteSpace != "":
+			return simple.whiteSpace
+		case simple.variety == "list":
+			return "collapse"
+		}
+		qn = simple.base
+	}
+	return ""
+}
+
+// the builtin an atomic type is derived from, if any
+func builtinBase(qn xml.Name, ctxt *context) (xml.Name, bool) {
+	for depth := 0; depth < 100; depth++ {
+		if isBuiltin(qn) {
+			return qn, true
+		}
+		simple, ok := ctxt.simpleTypes[qn]
+		if !ok || simple.variety == "list" || simple.variety == "union" {
+			break
+		}
+		qn = simple.base
+	}
+	return xml.Name{}, false
+}
+
+// a pattern for strings that are already normalised
+// replace leaves no tab, newline or carriage return; collapse leaves
+// single spaces between non-space characters
+func whiteSpacePattern(ws string) string {
+	switch ws {
+	case "replace":
+		return `[^\t\n\r]*`
+	case "collapse":
+		return `(?:[^ \t\n\r]+(?: [^ \t\n\r]+)*)?`
+	}
+	return ""
+}
+
+// the JSON literal for a value of the type
+// anything that isn't a valid number or boolean stays a string
+func jsonLiteral(value string, qn xml.Name, ctxt *context) string {
+	trimmed := strings.TrimSpace(value)
+	switch valueType(qn, ctxt) {
+	case "number":
+		if jsonNumber.MatchString(trimmed) {
+			return trimmed
+		}
+		if n, err := strconv.ParseFloat(trimmed, 64); err == nil && !math.IsInf(n, 0) && !math.IsNaN(n) {
+			return strconv.FormatFloat(n, 'f', -1, 64)
+		}
+	case "boolean":
+		switch trimmed {
+		case "true", "1":
+			return "true"
+		case "false", "0":
+			return "false"
+		}
+	}
+	return "\"" + jsonEscape(value) + "\""
+}
+
+// does this type have attributes, making its values objects with a #value?
+func hasAttrs(qn xml.Name, ctxt *context) bool {
+	simple, ok := ctxt.simpleTypes[qn]
+	return ok && (len(simple.attrs) > 0 || simple.anyAttr != nil)
+}
+
+// write the schema of a value of a type, with any default or fixed value
+// a fixed value is a const, or a single-value enum in draft 4 which has no const.
+// Keywords alongside a $ref are ignored, so a constrained reference
+// is wrapped in an allOf
+func writeValue(qn xml.Name, dflt string, fixed string, f io.Writer, ctxt *context, indent int) {
+	constrained := dflt != "" || fixed != ""
+	switch {
+	case constrained && !isBuiltin(qn) && qn.Local != "":
+		inPrintf(f, indent, "\"allOf\": [{\n")
+		if isInline(qn, ctxt) {
+			writeTypeBody(qn, f, ctxt, indent+tsz)
+		} else {
+			inPrintf(f, indent+tsz, "%s,\n", typeRef(qn, ctxt))
+		}
+		inPrintf(f, indent, "}],\n")
+	case isInline(qn, ctxt):
+		writeTypeBody(qn, f, ctxt, indent)
+	default:
+		inPrintf(f, indent, "%s,\n", typeRef(qn, ctxt))
+	}
+	if !constrained {
+		return
+	}
+	// the value of a type with attributes is its #value
+	if hasAttrs(qn, ctxt) {
+		inPrintf(f, indent, "\"properties\": {\n")
+		inPrintf(f, indent+tsz, "\"#value\": {\n")
+		writeValueConstraints(qn, dflt, fixed, f, ctxt, indent+tsz+tsz)
+		inPrintf(f, indent+tsz, "},\n")
+		inPrintf(f, indent, "},\n")
+	} else {
+		writeValueConstraints(qn, dflt, fixed, f, ctxt, indent)
+	}
+}
+
+// write "default" and the "const" (or single-value "enum") of a fixed value
+func writeValueConstraints(qn xml.Name, dflt string, fixed string, f io.Writer, ctxt *context, indent int) {
+	if dflt != "" {
+		inPrintf(f, indent, "\"default\": %s,\n", jsonLiteral(dflt, qn, ctxt))
+	}
+	switch {
+	case fixed == "":
+	case ctxt.draft < 6:
+		inPrintf(f, indent, "\"enum\": [%s],\n", jsonLiteral(fixed, qn, ctxt))
+	default:
+		inPrintf(f, indent, "\"const\": %s,\n", jsonLiteral(fixed, qn, ctxt))
+	}
+}
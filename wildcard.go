@@ -0,0 +1,209 @@
+// xsd2json - convert XSD files to JSON schema
+// Copyright (C) 2019  Tom Hay
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.package main
+
+// wildcard
+// xs:any and xs:anyAttribute: which names they allow, and how the
+// properties they open up are written
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// an xs:any or xs:anyAttribute
+type wildcard struct {
+	anyNs           bool     // ##any
+	namespaces      []string // allowed namespaces, "" for ##local
+	notNamespaces   []string // ##other or XSD 1.1 notNamespace
+	processContents string   // strict | lax | skip
+	minOccurs       int64
+	maxOccurs       int64
+}
+
+// the wildcard described by the attributes of xs:any or xs:anyAttribute
+// ##targetNamespace and ##other refer to the schema being parsed
+func newWildcard(attrs map[string]string, ctxt *context) wildcard {
+	w := wildcard{processContents: "strict", minOccurs: -1, maxOccurs: -1}
+	if pc, ok := attrs["processContents"]; ok {
+		w.processContents = pc
+	}
+	if n, ok := attrs["minOccurs"]; ok {
+		w.minOccurs = parseOccurs(n)
+	}
+	if n, ok := attrs["maxOccurs"]; ok {
+		w.maxOccurs = parseOccurs(n)
+	}
+	if not, ok := attrs["notNamespace"]; ok {
+		w.notNamespaces = wildcardNamespaces(not, ctxt)
+		return w
+	}
+	ns, ok := attrs["namespace"]
+	switch {
+	case !ok || ns == "##any":
+		w.anyNs = true
+	case ns == "##other":
+		w.notNamespaces = []string{ctxt.scope.targetNs, ""}
+	default:
+		w.namespaces = wildcardNamespaces(ns, ctxt)
+	}
+	return w
+}
+
+// the namespaces of a namespace list
+func wildcardNamespaces(list string, ctxt *context) []string {
+	namespaces := make([]string, 0)
+	for _, ns := range strings.Fields(list) {
+		switch ns {
+		case "##targetNamespace":
+			namespaces = append(namespaces, ctxt.scope.targetNs)
+		case "##local":
+			namespaces = append(namespaces, "")
+		default:
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+// does the wildcard allow a name in this namespace?
+func (w wildcard) allows(ns string) bool {
+	if len(w.notNamespaces) > 0 {
+		for _, not := range w.notNamespaces {
+			if ns == not {
+				return false
+			}
+		}
+		return true
+	}
+	if w.anyNs {
+		return true
+	}
+	for _, allowed := range w.namespaces {
+		if ns == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// the global elements a wildcard allows, which aren't already children
+// of the type; strict and lax wildcards validate them against their
+// declarations, so they become properties
+func (w wildcard) globalElems(cmplx complexType, ctxt *context) []xml.Name {
+	names := make([]xml.Name, 0)
+	if w.processContents == "skip" {
+		return names
+	}
+	for qn, el := range ctxt.elements {
+		if _, found := cmplx.findElem(qn.Local); !found && !el.abstract && w.allows(qn.Space) {
+			names = append(names, qn)
+		}
+	}
+	sortNames(names)
+	return names
+}
+
+// the global elements a complex type's element wildcards allow
+// how often each may occur is worked out from the content, as for children
+func wildcardElems(cmplx complexType, ctxt *context) []element {
+	elems := make([]element, 0)
+	names := make([]xml.Name, 0)
+	for _, w := range cmplx.anyElems {
+		for _, qn := range w.globalElems(cmplx, ctxt) {
+			if !containsName(names, qn) {
+				names = append(names, qn)
+				elems = append(elems, element{name: qn.Local, ns: qn.Space, ref: qn, maxOccurs: w.maxOccurs})
+			}
+		}
+	}
+	return elems
+}
+
+// the schema requiring an element a wildcard allows: a global element
+// it lets in, or for lax and skip any element that isn't declared
+// "" if there is no such element
+func wildcardPresence(w wildcard, cmplx complexType, ctxt *context) string {
+	alternatives := make([]string, 0)
+	for _, qn := range w.globalElems(cmplx, ctxt) {
+		alternatives = append(alternatives, fmt.Sprintf("{\"required\": [\"%s\"]}", qn.Local))
+	}
+	if w.processContents != "strict" {
+		declared := make([]string, 0)
+		for _, el := range cmplx.elems {
+			declared = append(declared, regexp.QuoteMeta(el.getName()))
+		}
+		for _, el := range wildcardElems(cmplx, ctxt) {
+			declared = append(declared, regexp.QuoteMeta(el.getName()))
+		}
+		// some property matches the pattern of undeclared elements
+		pattern := jsonEscape("^(?![@#])" + notNames(declared))
+		alternatives = append(alternatives, fmt.Sprintf("{\"not\": {\"patternProperties\": {\"%s\": {\"not\": {}}}}}", pattern))
+	}
+	if len(alternatives) == 0 {
+		return ""
+	}
+	return "\"anyOf\": [" + strings.Join(alternatives, ", ") + "]"
+}
+
+// does any element wildcard let through elements with no declaration?
+func opensElems(cmplx complexType) bool {
+	for _, w := range cmplx.anyElems {
+		if w.processContents != "strict" {
+			return true
+		}
+	}
+	return false
+}
+
+// write the patternProperties for the properties the wildcards leave open:
+// undeclared elements for lax or skip xs:any, and undeclared attributes
+// ("@" names) for xs:anyAttribute. Global attributes aren't kept, so a
+// strict xs:anyAttribute is treated as lax.
+// declared are the names of the properties already written.
+func writeWildcards(openElems bool, anyAttr *wildcard, declared []string, f io.Writer, ctxt *context, indent int) {
+	if !openElems && anyAttr == nil {
+		return
+	}
+	attrs, elems := make([]string, 0), make([]string, 0)
+	for _, name := range declared {
+		if strings.HasPrefix(name, "@") {
+			attrs = append(attrs, regexp.QuoteMeta(name[1:]))
+		} else {
+			elems = append(elems, regexp.QuoteMeta(name))
+		}
+	}
+	inPrintf(f, indent, "\"patternProperties\": {\n")
+	if openElems {
+		inPrintf(f, indent+tsz, "\"%s\": {},\n", jsonEscape("^(?![@#])"+notNames(elems)))
+	}
+	if anyAttr != nil {
+		inPrintf(f, indent+tsz, "\"%s\": {},\n", jsonEscape("^@"+notNames(attrs)))
+	}
+	inPrintf(f, indent, "},\n")
+}
+
+// a lookahead excluding the names
+func notNames(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return "(?!(?:" + strings.Join(names, "|") + ")$)"
+}
@@ -20,10 +20,13 @@
 package main
 
 import (
+	"encoding/xml"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -34,9 +37,9 @@ const tsz = 3 // tab size
 func writeJson(f io.Writer, ctxt *context) {
 	inPrintf(f, 0, "{\n")
 
+	assignDefNames(ctxt)
 	writeHdrs(f, ctxt, tsz)
-	rootType := ctxt.complexTypes[ctxt.root.getName()]
-	writeComplexBody(rootType, f, ctxt, tsz)
+	writeRoots(f, ctxt, tsz)
 
 	writeDefinitions(f, ctxt, tsz)
 
@@ -48,19 +51,113 @@ func writeName(n named, f io.Writer, ctxt *context, indent int) {
 	inPrintf(f, indent, "\"%s\": {\n", n.getName())
 }
 
+// write the definition name of a type
+func writeDefName(qn xml.Name, f io.Writer, ctxt *context, indent int) {
+	inPrintf(f, indent, "\"%s\": {\n", defName(qn, ctxt))
+}
+
+// the schema for a value of the named type:
+// builtins map directly to a JSON type, anything else is a $ref
+func typeRef(qn xml.Name, ctxt *context) string {
+	if qn.Local == "" { // no type given, so anything goes
+		return "\"$comment\": \"XML datatype was xs:anyType\""
+	}
+	if isBuiltin(qn) {
+		if item, isList := xlistItems[qn.Local]; isList {
+			return listRef(xml.Name{Space: xsdNs, Local: item}, ctxt)
+		}
+		jtype, _ := mapTypename(qn)
+		keywords := append([]string{fmt.Sprintf("\"type\": \"%s\"", jtype)}, builtinKeywords(qn, ctxt)...)
+		if pattern := builtinPattern(qn); pattern != "" {
+			keywords = append(keywords, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(pattern))))
+		}
+		// string values are written normalised, as for a simple type
+		if ws := builtinWhiteSpace(qn); xstringTypes[qn.Local] && whiteSpacePattern(ws) != "" {
+			keywords = append(keywords, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(whiteSpacePattern(ws)))))
+			keywords = append(keywords, fmt.Sprintf("\"x-whiteSpace\": \"%s\"", ws))
+		}
+		return strings.Join(keywords, ", ")
+	}
+	return fmt.Sprintf("\"$ref\": \"#/definitions/%s\"", defName(qn, ctxt))
+}
+
+// is this type to be written in place rather than as a definition?
+// a recursive type is always a definition, so that it can refer to itself
+func isInline(qn xml.Name, ctxt *context) bool {
+	return ctxt.anonInline && isAnonymous(qn) && !isRecursiveType(qn, ctxt)
+}
+
+// write the body of any simple or complex type
+func writeTypeBody(qn xml.Name, f io.Writer, ctxt *context, indent int) {
+	if simple, ok := ctxt.simpleTypes[qn]; ok {
+		writeSimpleBody(simple, f, ctxt, indent)
+	} else if cmplx, ok := ctxt.complexTypes[qn]; ok {
+		writeComplexBody(cmplx, f, ctxt, indent)
+	} else {
+		diagnoseComponent(ctxt, sevError, typeNode{name: qn}, "type not found")
+	}
+}
+
 // write an element
 // if multiple occurrences are allowed, make it an array of items
 // of the specified type
+// a reference to a global element refers to its definition
 func writeElement(el element, f io.Writer, ctxt *context, indent int) {
 	if el.maxOccurs > 1 {
 		inPrintf(f, indent, "\"%s\": {\n", el.getName())
+		writeAnnotations(el.ann, f, ctxt, indent+tsz)
 		inPrintf(f, indent+tsz, "\"type\": \"array\",\n")
+		if min := occurs(el.minOccurs); min > 1 {
+			inPrintf(f, indent+tsz, "\"minItems\": %d,\n", min)
+		}
+		if el.maxOccurs < 9999999 {
+			inPrintf(f, indent+tsz, "\"maxItems\": %d,\n", el.maxOccurs)
+		}
 		inPrintf(f, indent+tsz, "\"items\": {\n")
-		inPrintf(f, indent+tsz+tsz, "\"$ref\": \"#/definitions/%s\",\n", el.etype)
+		writeElementBody(el, f, ctxt, indent+tsz+tsz)
 		inPrintf(f, indent+tsz, "},\n")
 		inPrintf(f, indent, "},\n")
+	} else if el.ref.Local == "" && !isInline(el.etype, ctxt) && el.ann.isEmpty() && el.edefault == "" && el.fixed == "" && !el.nillable {
+		inPrintf(f, indent, "\"%s\": {%s },\n", el.getName(), typeRef(el.etype, ctxt))
+	} else if isInline(el.etype, ctxt) && !el.ann.isEmpty() && !typeAnnotations(el.etype, ctxt).isEmpty() {
+		// the element and its anonymous type both have a description
+		inPrintf(f, indent, "\"%s\": {\n", el.getName())
+		writeAnnotations(el.ann, f, ctxt, indent+tsz)
+		inPrintf(f, indent+tsz, "\"allOf\": [{\n")
+		writeElementBody(el, f, ctxt, indent+tsz+tsz)
+		inPrintf(f, indent+tsz, "}],\n")
+		inPrintf(f, indent, "},\n")
 	} else {
-		inPrintf(f, indent, "\"%s\": {\"$ref\": \"#/definitions/%s\" },\n", el.getName(), el.etype)
+		inPrintf(f, indent, "\"%s\": {\n", el.getName())
+		writeAnnotations(el.ann, f, ctxt, indent+tsz)
+		writeElementBody(el, f, ctxt, indent+tsz)
+		inPrintf(f, indent, "},\n")
+	}
+}
+
+// the annotations of a simple or complex type
+func typeAnnotations(qn xml.Name, ctxt *context) annotations {
+	if simple, ok := ctxt.simpleTypes[qn]; ok {
+		return simple.ann
+	}
+	return ctxt.complexTypes[qn].ann
+}
+
+// write the schema for a single occurrence of an element
+// a nillable element may also be null, standing for xsi:nil="true"
+func writeElementBody(el element, f io.Writer, ctxt *context, indent int) {
+	switch {
+	case el.ref.Local != "":
+		inPrintf(f, indent, "\"$ref\": \"#/definitions/%s\",\n", elemDefName(el.ref, ctxt))
+	case el.nillable:
+		inPrintf(f, indent, "\"anyOf\": [\n")
+		inPrintf(f, indent+tsz, "{\n")
+		writeValue(el.etype, el.edefault, el.fixed, f, ctxt, indent+tsz+tsz)
+		inPrintf(f, indent+tsz, "},\n")
+		inPrintf(f, indent+tsz, "{\"type\": \"null\"},\n")
+		inPrintf(f, indent, "],\n")
+	default:
+		writeValue(el.etype, el.edefault, el.fixed, f, ctxt, indent)
 	}
 }
 
@@ -69,27 +166,180 @@ func writeElement(el element, f io.Writer, ctxt *context, indent int) {
 // the #value element represents the base type
 // each attribute forms a separate element named @Attributename
 func writeSimpleBody(simple simpleType, f io.Writer, ctxt *context, indent int) {
-	if len(simple.attrs) > 0 {
+	writeAnnotations(simple.ann, f, ctxt, indent)
+	if len(simple.attrs) > 0 || simple.anyAttr != nil {
 		inPrintf(f, indent, "\"type\": \"object\",\n")
 		inPrintf(f, indent, "\"properties\": {\n")
 		inPrintf(f, indent+tsz, "\"#value\": {\n")
-		writeSimpleProperties(simple, f, ctxt, indent+tsz+tsz)
+		value := simple
+		value.asserts = nil // written for the whole object
+		writeSimpleProperties(value, f, ctxt, indent+tsz+tsz)
 		inPrintf(f, indent+tsz, "},\n")
 		required := writeAttrs(simple, f, ctxt, indent+tsz)
 		inPrintf(f, indent, "},\n")
-		inPrintf(f, indent, "\"required\": %s\n,", arrayString(required))
+		inPrintf(f, indent, "\"required\": %s,\n", arrayString(required))
+		// assertions may test the attributes as well as the #value
+		schemas, tests := splitAssertions(simple.asserts)
+		if len(schemas) > 0 {
+			inPrintf(f, indent, "\"allOf\": [\n")
+			for _, schema := range schemas {
+				inPrintf(f, indent+tsz, "{%s},\n", schema)
+			}
+			inPrintf(f, indent, "],\n")
+		}
+		writeUntranslated(tests, f, ctxt, indent)
+		writeWildcards(false, simple.anyAttr, attrNames(simple.attrs), f, ctxt, indent)
 		inPrintf(f, indent, "\"additionalProperties\": false,\n")
 	} else {
 		writeSimpleProperties(simple, f, ctxt, indent)
 	}
 }
 
+// the schema for a whitespace separated list of the item type,
+// either as a string with a pattern or as an array
+func listRef(item xml.Name, ctxt *context) string {
+	if ctxt.listArrays {
+		return fmt.Sprintf("\"type\": \"array\", \"items\": {%s}", typeRef(item, ctxt))
+	}
+	return fmt.Sprintf("\"type\": \"string\", \"pattern\": \"%s\"", jsonEscape(listPattern(item, ctxt)))
+}
+
+// a pattern for a list of space separated items
+// the items' own pattern or enumeration is used when there is one
+func listPattern(item xml.Name, ctxt *context) string {
+	itemPattern := "[^ \\t\\n\\r]+"
+	if simple, ok := ctxt.simpleTypes[item]; ok {
+		switch {
+		case len(simple.patterns) > 0 && len(simple.patterns[len(simple.patterns)-1]) > 0:
+			itemPattern = strings.Join(simple.patterns[len(simple.patterns)-1], "|")
+		case len(simple.enum) > 0:
+			quoted := make([]string, len(simple.enum))
+			for i, value := range simple.enum {
+				quoted[i] = regexp.QuoteMeta(value)
+			}
+			itemPattern = strings.Join(quoted, "|")
+		}
+	}
+	return fmt.Sprintf("^(?:%s)(?: (?:%s))*$", itemPattern, itemPattern)
+}
+
+// the pattern constraints of each derivation step of a simple type:
+// XSD ORs the patterns of one step together, so several become an anyOf
+func patternSchemas(simple simpleType) []string {
+	steps := make([]string, 0)
+	for _, patterns := range simple.patterns {
+		switch len(patterns) {
+		case 0:
+		case 1:
+			steps = append(steps, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(patterns[0]))))
+		default:
+			alts := make([]string, len(patterns))
+			for i, pattern := range patterns {
+				alts[i] = fmt.Sprintf("{\"pattern\": \"%s\"}", jsonEscape(anchorPattern(pattern)))
+			}
+			steps = append(steps, fmt.Sprintf("\"anyOf\": [%s]", strings.Join(alts, ", ")))
+		}
+	}
+	return steps
+}
+
+// an XSD pattern always matches the whole value, a JSON schema one
+// anywhere in it
+func anchorPattern(pattern string) string {
+	return "^(?:" + pattern + ")$"
+}
+
+// write a union as anyOf its member types
+func writeUnion(simple simpleType, f io.Writer, ctxt *context, indent int) {
+	inPrintf(f, indent, "\"anyOf\": [\n")
+	for _, member := range simple.memberTypes {
+		if isInline(member, ctxt) {
+			inPrintf(f, indent+tsz, "{\n")
+			writeTypeBody(member, f, ctxt, indent+tsz+tsz)
+			inPrintf(f, indent+tsz, "},\n")
+		} else {
+			inPrintf(f, indent+tsz, "{%s},\n", typeRef(member, ctxt))
+		}
+	}
+	inPrintf(f, indent, "],\n")
+}
+
+// write a list, as a string or an array depending on the -list option
+func writeList(simple simpleType, f io.Writer, ctxt *context, indent int) {
+	if ctxt.listArrays && isInline(simple.itemType, ctxt) {
+		inPrintf(f, indent, "\"type\": \"array\",\n")
+		inPrintf(f, indent, "\"items\": {\n")
+		writeTypeBody(simple.itemType, f, ctxt, indent+tsz)
+		inPrintf(f, indent, "},\n")
+		return
+	}
+	inPrintf(f, indent, "%s,\n", listRef(simple.itemType, ctxt))
+}
+
 // write the properties of a simple type
 func writeSimpleProperties(simple simpleType, f io.Writer, ctxt *context, indent int) {
-	jtype, mapped := mapTypename(simple.base)
-	inPrintf(f, indent, "\"type\": \"%s\",\n", jtype)
-	if mapped {
-		inPrintf(f, indent, "\"$comment\": \"XML datatype was %s\",\n", simple.base)
+	schemas, tests := splitAssertions(simple.asserts)
+	switch simple.variety {
+	case "union":
+		writeUnion(simple, f, ctxt, indent)
+		writeUntranslated(tests, f, ctxt, indent)
+		return
+	case "list":
+		writeList(simple, f, ctxt, indent)
+		writeUntranslated(tests, f, ctxt, indent)
+		return
+	}
+	steps := append(patternSchemas(simple), schemas...)
+	asString := digitsAsString(simple, ctxt)
+	if asString {
+		steps = append(steps, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(digitsPattern(simple)))))
+	}
+	// string values are written normalised, as set by whiteSpace
+	ws := simple.whiteSpace
+	if ws == "" {
+		ws = whiteSpace(simple.base, ctxt)
+	}
+	builtin, _ := builtinBase(simple.base, ctxt)
+	isString := xstringTypes[builtin.Local]
+	if isString && len(simple.enum) == 0 && (simple.whiteSpace != "" || isBuiltin(simple.base)) && whiteSpacePattern(ws) != "" {
+		steps = append(steps, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(whiteSpacePattern(ws)))))
+	}
+	// and the lexical space of dates, times and binaries
+	if pattern := builtinPattern(simple.base); pattern != "" && len(simple.enum) == 0 {
+		steps = append(steps, fmt.Sprintf("\"pattern\": \"%s\"", jsonEscape(anchorPattern(pattern))))
+	}
+	// a single $comment, as a key may only appear once
+	notes := boundsNotes(simple, asString)
+	if !isBuiltin(simple.base) && simple.base.Local != "" {
+		// a user type's own facets apply on top of those of its base
+		inPrintf(f, indent, "\"allOf\": [\n")
+		inPrintf(f, indent+tsz, "{\n")
+		if isInline(simple.base, ctxt) {
+			writeTypeBody(simple.base, f, ctxt, indent+tsz+tsz)
+		} else {
+			inPrintf(f, indent+tsz+tsz, "%s,\n", typeRef(simple.base, ctxt))
+		}
+		inPrintf(f, indent+tsz, "},\n")
+		for _, step := range steps {
+			inPrintf(f, indent+tsz, "{%s},\n", step)
+		}
+		inPrintf(f, indent, "],\n")
+		steps = nil
+	} else {
+		jtype, mapped := mapTypename(simple.base)
+		if asString {
+			jtype = "string"
+		}
+		inPrintf(f, indent, "\"type\": \"%s\",\n", jtype)
+		if mapped {
+			notes = append([]string{"XML datatype was xs:" + simple.base.Local}, notes...)
+		}
+		for _, keyword := range builtinKeywords(simple.base, ctxt) {
+			inPrintf(f, indent, "%s,\n", keyword)
+		}
+	}
+	if len(notes) > 0 {
+		inPrintf(f, indent, "\"$comment\": \"%s\",\n", jsonEscape(strings.Join(notes, "; ")))
 	}
 	// string constraints
 	if simple.minLength > -1 {
@@ -104,34 +354,42 @@ func writeSimpleProperties(simple simpleType, f io.Writer, ctxt *context, indent
 	}
 	if len(simple.enum) > 0 {
 		inPrintf(f, indent, "\"enum\": %s,\n", arrayString(simple.enum))
+		writeEnumDescriptions(simple, f, ctxt, indent)
 	}
-	if simple.pattern != "" {
-		// double all slashes to make valid JSON escapes
-		escaped := strings.Replace(simple.pattern, "\\", "\\\\", -1)
-		inPrintf(f, indent, "\"pattern\": \"%s\",\n", escaped)
-	}
-	// number constraints
-	if simple.minInclusive > -1 {
-		inPrintf(f, indent, "\"minimum\": %d,\n", simple.minInclusive)
-	}
-	if simple.minExclusive > -1 {
-		inPrintf(f, indent, "\"exclusiveMinimum\": %d,\n", simple.minExclusive)
-	}
-	if simple.maxInclusive > -1 {
-		inPrintf(f, indent, "\"maximum\": %d,\n", simple.maxInclusive)
+	switch len(steps) {
+	case 0:
+	case 1:
+		inPrintf(f, indent, "%s,\n", steps[0])
+	default:
+		inPrintf(f, indent, "\"allOf\": [\n")
+		for _, step := range steps {
+			inPrintf(f, indent+tsz, "{%s},\n", step)
+		}
+		inPrintf(f, indent, "],\n")
 	}
-	if simple.maxExclusive > -1 {
-		inPrintf(f, indent, "\"exclusiveMaximum\": %d,\n", simple.maxExclusive)
+	// number constraints, only noted in the $comment of a string
+	if !asString {
+		writeBounds(simple, f, ctxt, indent)
+		writeMultipleOf(simple, f, ctxt, indent)
 	}
-	// JSON schema can't handle these rules
-	if simple.totalDigits > -1 {
-		inPrintf(f, indent, "\"$comment\": \"XML specified totalDigits=%d\",\n", simple.totalDigits)
+	// for converters, which normalise values to match
+	if isString && ws != "" && ws != "preserve" {
+		inPrintf(f, indent, "\"x-whiteSpace\": \"%s\",\n", ws)
 	}
-	if simple.fractionDigits > -1 {
-		inPrintf(f, indent, "\"$comment\": \"XML specified fractionDigits=%d\",\n", simple.fractionDigits)
+	writeUntranslated(tests, f, ctxt, indent)
+}
+
+// write the documentation of each enumeration value, if any, as
+// "x-enumDescriptions": {"value": "description", ...}
+func writeEnumDescriptions(simple simpleType, f io.Writer, ctxt *context, indent int) {
+	descs := make([]string, 0)
+	for i, ann := range simple.enumAnns {
+		if ann.doc != "" {
+			descs = append(descs, fmt.Sprintf("\"%s\": \"%s\"", jsonEscape(simple.enum[i]), jsonEscape(ann.doc)))
+		}
 	}
-	if simple.whiteSpace != "" {
-		inPrintf(f, indent, "\"$comment\": \"XML specified whiteSpace=%s\",\n", simple.whiteSpace)
+	if len(descs) > 0 {
+		inPrintf(f, indent, "\"x-enumDescriptions\": {%s},\n", strings.Join(descs, ", "))
 	}
 }
 
@@ -149,13 +407,13 @@ func writeHdrs(f io.Writer, ctxt *context, indent int) {
 	}
 	hdrs := [...]string{
 		"\"$id\": \"" + domain + "/" + ctxt.outFileBase + "\",\n",
-		"\"$schema\": \"http://json-schema.org/draft-04/schema#\",\n",
+		"\"$schema\": \"http://json-schema.org/draft-0" + strconv.Itoa(ctxt.draft) + "/schema#\",\n",
 		"\"title\": \"" + ctxt.outFileBase + "\",\n",
 		"\"description\": \"Derived from " + ctxt.inFileBase + " by '" + filepath.Base(os.Args[0]) + "' on " + when + ".\",\n",
 		"\n",
 	}
 	for _, str := range hdrs {
-		inPrintf(f, indent, str)
+		inPrintf(f, indent, "%s", str)
 	}
 }
 
@@ -169,76 +427,455 @@ func writeDefinitions(f io.Writer, ctxt *context, indent int) {
 	inPrintf(f, indent, "\n")
 	inPrintf(f, indent+tsz, "\"$comment\": \"---Simple type definitions---\",\n")
 	for _, simple := range ctxt.simpleTypes {
-		writeSimple(simple, f, ctxt, indent+tsz)
+		if !isInline(simple.name, ctxt) {
+			writeSimple(simple, f, ctxt, indent+tsz)
+		}
 	}
 	// now print all the complex type definitions
 	inPrintf(f, indent, "\n")
 	inPrintf(f, indent+tsz, "\"$comment\": \"---Complex type definitions---\",\n")
 	for _, cmplx := range ctxt.complexTypes {
-		writeComplex(cmplx, f, ctxt, indent+tsz)
+		if !isInline(cmplx.name, ctxt) {
+			writeComplex(cmplx, f, ctxt, indent+tsz)
+		}
+	}
+	// and the global elements, which refer to their types
+	inPrintf(f, indent, "\n")
+	inPrintf(f, indent+tsz, "\"$comment\": \"---Element definitions---\",\n")
+	for qn, el := range ctxt.elements {
+		inPrintf(f, indent+tsz, "\"%s\": {\n", elemDefName(qn, ctxt))
+		writeElementDef(qn, *el, f, ctxt, indent+tsz+tsz)
+		writeClose(f, ctxt, indent+tsz)
 	}
 	inPrintf(f, indent, "}\n")
 }
 
+// write the body of a global element definition
+// the head of a substitution group may be replaced by any of its concrete
+// members, so it becomes a oneOf, each wrapped by the element's name:
+// "oneOf": [
+// {"type": "object", "properties": {"Cat": {...}}, "required": ["Cat"], ...},
+// {"type": "object", "properties": {"Dog": {...}}, "required": ["Dog"], ...},
+// ],
+// An abstract element never appears itself.
+func writeElementDef(qn xml.Name, el element, f io.Writer, ctxt *context, indent int) {
+	writeAnnotations(el.ann, f, ctxt, indent)
+	members := substMembers(qn, ctxt)
+	if len(members) == 0 && !el.abstract {
+		writeElementBody(el, f, ctxt, indent)
+		return
+	}
+	if !el.abstract {
+		members = append([]xml.Name{qn}, members...)
+	}
+	if len(members) == 0 {
+		inPrintf(f, indent, "\"$comment\": \"abstract element with no substitutes\",\n")
+		inPrintf(f, indent, "\"not\": {},\n")
+		return
+	}
+	inPrintf(f, indent, "\"oneOf\": [\n")
+	for _, member := range members {
+		inPrintf(f, indent+tsz, "{\n")
+		inPrintf(f, indent+tsz+tsz, "\"type\": \"object\",\n")
+		inPrintf(f, indent+tsz+tsz, "\"properties\": {\n")
+		inPrintf(f, indent+tsz+tsz+tsz, "\"%s\": {\n", member.Local)
+		writeElementBody(*ctxt.elements[member], f, ctxt, indent+tsz+tsz+tsz+tsz)
+		inPrintf(f, indent+tsz+tsz+tsz, "},\n")
+		inPrintf(f, indent+tsz+tsz, "},\n")
+		inPrintf(f, indent+tsz+tsz, "\"required\": [\"%s\"],\n", member.Local)
+		inPrintf(f, indent+tsz+tsz, "\"additionalProperties\": false,\n")
+		inPrintf(f, indent+tsz, "},\n")
+	}
+	inPrintf(f, indent, "],\n")
+}
+
+// write the body of an abstract complex type: any of the concrete
+// types derived from it, but never the type itself
+// anyOf, since an instance of a type may also match one derived from it
+func writeAbstractBody(cmplx complexType, f io.Writer, ctxt *context, indent int) {
+	derived := derivedTypes(cmplx.name, ctxt)
+	inPrintf(f, indent, "\"$comment\": \"abstract type %s\",\n", defName(cmplx.name, ctxt))
+	if len(derived) == 0 {
+		inPrintf(f, indent, "\"not\": {},\n")
+		return
+	}
+	inPrintf(f, indent, "\"anyOf\": [\n")
+	for _, qn := range derived {
+		inPrintf(f, indent+tsz, "{%s},\n", typeRef(qn, ctxt))
+	}
+	inPrintf(f, indent, "],\n")
+}
+
 // write a simple type definition
 func writeSimple(simple simpleType, f io.Writer, ctxt *context, indent int) {
-	writeName(simple, f, ctxt, indent)
+	writeDefName(simple.name, f, ctxt, indent)
 	writeSimpleBody(simple, f, ctxt, indent+tsz)
 	writeClose(f, ctxt, indent)
 }
 
 // write a complex type definition
 func writeComplex(cmplx complexType, f io.Writer, ctxt *context, indent int) {
-	writeName(cmplx, f, ctxt, indent)
+	writeDefName(cmplx.name, f, ctxt, indent)
 	writeComplexBody(cmplx, f, ctxt, indent+tsz)
 	writeClose(f, ctxt, indent)
 }
 
 // write the body of a complex type
 func writeComplexBody(cmplx complexType, f io.Writer, ctxt *context, indent int) {
+	writeAnnotations(cmplx.ann, f, ctxt, indent)
+	if cmplx.abstract {
+		writeAbstractBody(cmplx, f, ctxt, indent)
+		return
+	}
 	// if it's based on simple, do simple body
 	if cmplx.simpleBase != nil {
-		fmt.Printf("Doing simple body for %s: %v\n", cmplx.name, *cmplx.simpleBase)
+		fmt.Printf("Doing simple body for %s: %v\n", defName(cmplx.name, ctxt), *cmplx.simpleBase)
 		writeSimpleBody(*cmplx.simpleBase, f, ctxt, indent+tsz)
 		return
 	}
+	if cmplx.mixed && ctxt.mixedOrdered {
+		writeMixedBody(cmplx, f, ctxt, indent)
+		return
+	}
 	inPrintf(f, indent, "\"type\": \"object\",\n")
 	inPrintf(f, indent, "\"properties\": {\n")
 	if len(cmplx.attrs) > 0 {
-		fmt.Printf("Doing attrs for complex %s\n", cmplx.name)
+		fmt.Printf("Doing attrs for complex %s\n", defName(cmplx.name, ctxt))
 		writeAttrs(cmplx, f, ctxt, indent+tsz)
 	}
-	required := make([]string, 0)
+	if cmplx.mixed { // the text between the child elements, in order
+		inPrintf(f, indent+tsz, "\"#text\": {\"type\": \"array\", \"items\": {\"type\": \"string\"}},\n")
+	}
+	// elements in a repeating compositor repeat too
+	maxes := make(map[string]int64)
+	elemMaxOccurs(cmplx.content, 1, cmplx, maxes, ctxt)
+	declared := attrNames(cmplx.attrs)
 	for _, el := range cmplx.elems {
+		if max, ok := maxes[el.name]; ok {
+			el.maxOccurs = max
+		}
 		writeElement(el, f, ctxt, indent+tsz)
-		if el.minOccurs != 0 {
-			required = append(required, el.getName())
+		declared = append(declared, el.getName())
+	}
+	// the declared elements that xs:any lets in
+	for _, el := range wildcardElems(cmplx, ctxt) {
+		if max, ok := maxes[el.name]; ok {
+			el.maxOccurs = max
 		}
+		writeElement(el, f, ctxt, indent+tsz)
+		declared = append(declared, el.getName())
 	}
 	inPrintf(f, indent, "},\n")
 
-	switch cmplx.etype {
+	// translated assertions must hold as well as the content model
+	schemas, tests := splitAssertions(cmplx.asserts)
+	switch {
+	case len(schemas) > 0:
+		inPrintf(f, indent, "\"allOf\": [\n")
+		if cmplx.content != nil {
+			inPrintf(f, indent+tsz, "{\n")
+			writeCompositor(cmplx.content, cmplx, f, ctxt, indent+tsz+tsz)
+			inPrintf(f, indent+tsz, "},\n")
+		}
+		for _, schema := range schemas {
+			inPrintf(f, indent+tsz, "{%s},\n", schema)
+		}
+		inPrintf(f, indent, "],\n")
+	case cmplx.content != nil:
+		writeCompositor(cmplx.content, cmplx, f, ctxt, indent)
+	}
+	writeUntranslated(tests, f, ctxt, indent)
+	writeWildcards(opensElems(cmplx), cmplx.anyAttr, declared, f, ctxt, indent)
+	inPrintf(f, indent, "\"additionalProperties\": false,\n")
+}
+
+// the property names of the attributes
+func attrNames(attrs []attribute) []string {
+	names := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		if !attr.prohibited {
+			names = append(names, "@"+attr.name)
+		}
+	}
+	return names
+}
+
+// write the body of a complex type with mixed content as an ordered array
+// of segments, each either text or an object holding a single child element:
+// "#mixed": ["Dear ", {"Name": "Fred"}, ", thank you"]
+// The order and occurrences of the children are not enforced
+func writeMixedBody(cmplx complexType, f io.Writer, ctxt *context, indent int) {
+	inPrintf(f, indent, "\"type\": \"object\",\n")
+	inPrintf(f, indent, "\"properties\": {\n")
+	writeAttrs(cmplx, f, ctxt, indent+tsz)
+	inPrintf(f, indent+tsz, "\"#mixed\": {\n")
+	inPrintf(f, indent+tsz+tsz, "\"type\": \"array\",\n")
+	inPrintf(f, indent+tsz+tsz, "\"items\": {\n")
+	inPrintf(f, indent+tsz+tsz+tsz, "\"anyOf\": [\n")
+	in := indent + 4*tsz
+	inPrintf(f, in, "{\"type\": \"string\"},\n")
+	elems := append(append(make([]element, 0), cmplx.elems...), wildcardElems(cmplx, ctxt)...)
+	for _, el := range elems {
+		inPrintf(f, in, "{\n")
+		inPrintf(f, in+tsz, "\"type\": \"object\",\n")
+		inPrintf(f, in+tsz, "\"properties\": {\n")
+		inPrintf(f, in+tsz+tsz, "\"%s\": {\n", el.getName())
+		writeElementBody(el, f, ctxt, in+3*tsz)
+		inPrintf(f, in+tsz+tsz, "},\n")
+		inPrintf(f, in+tsz, "},\n")
+		inPrintf(f, in+tsz, "\"required\": [\"%s\"],\n", el.getName())
+		inPrintf(f, in+tsz, "\"additionalProperties\": false,\n")
+		inPrintf(f, in, "},\n")
+	}
+	if opensElems(cmplx) { // undeclared elements let in by xs:any
+		inPrintf(f, in, "{\"type\": \"object\", \"minProperties\": 1, \"maxProperties\": 1},\n")
+	}
+	inPrintf(f, indent+tsz+tsz+tsz, "],\n")
+	inPrintf(f, indent+tsz+tsz, "},\n")
+	inPrintf(f, indent+tsz, "},\n")
+	inPrintf(f, indent, "},\n")
+	inPrintf(f, indent, "\"$comment\": \"mixed content: order and occurrences of child elements not enforced\",\n")
+	_, tests := splitAssertions(cmplx.asserts)
+	writeUntranslated(tests, f, ctxt, indent)
+	writeWildcards(false, cmplx.anyAttr, attrNames(cmplx.attrs), f, ctxt, indent)
+	inPrintf(f, indent, "\"additionalProperties\": false,\n")
+}
+
+// the number of occurrences, defaulting to 1
+func occurs(n int64) int64 {
+	if n < 0 {
+		return 1
+	}
+	return n
+}
+
+// work out the maxOccurs of each element, multiplied by the
+// maxOccurs of all the compositors that enclose it
+// an element in several particles of a sequence may occur as often as
+// all of them together, in a choice as often as the most frequent one
+// the global elements a wildcard lets in occur as often as it does
+func elemMaxOccurs(comp *compositor, outer int64, cmplx complexType, maxes map[string]int64, ctxt *context) {
+	if comp == nil {
+		return
+	}
+	outer = capOccurs(outer * occurs(comp.maxOccurs))
+	for _, p := range comp.parts {
+		part := make(map[string]int64)
+		if p.comp != nil {
+			elemMaxOccurs(p.comp, outer, cmplx, part, ctxt)
+		} else if p.wild != nil {
+			for _, qn := range p.wild.globalElems(cmplx, ctxt) {
+				part[qn.Local] = capOccurs(outer * occurs(p.wild.maxOccurs))
+			}
+		} else if el, ok := cmplx.findElem(p.elem); ok {
+			part[p.elem] = capOccurs(outer * occurs(el.maxOccurs))
+		}
+		for name, max := range part {
+			if comp.ctype != "choice" {
+				maxes[name] = capOccurs(maxes[name] + max)
+			} else if max > maxes[name] {
+				maxes[name] = max
+			}
+		}
+	}
+}
+
+// is a string in the list?
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// occurrences beyond this are unbounded
+func capOccurs(n int64) int64 {
+	if n > 9999999 {
+		return 9999999
+	}
+	return n
+}
+
+// the names of all the elements within a compositor, each once
+func compElemNames(comp *compositor) []string {
+	names := make([]string, 0)
+	for _, p := range comp.parts {
+		if p.comp != nil {
+			for _, name := range compElemNames(p.comp) {
+				if !containsString(names, name) {
+					names = append(names, name)
+				}
+			}
+		} else if p.wild == nil && !containsString(names, p.elem) {
+			names = append(names, p.elem)
+		}
+	}
+	return names
+}
+
+// a required schema for each name, for an anyOf or oneOf
+func requiredEach(names []string) string {
+	schemas := make([]string, len(names))
+	for i, name := range names {
+		schemas[i] = fmt.Sprintf("{\"required\": [\"%s\"]}", name)
+	}
+	return strings.Join(schemas, ", ")
+}
+
+// the number of element particles within a compositor
+func countElems(comp *compositor) int {
+	n := 0
+	for _, p := range comp.parts {
+		if p.comp != nil {
+			n += countElems(p.comp)
+		} else if p.wild == nil {
+			n++
+		}
+	}
+	return n
+}
+
+// can a compositor be satisfied with none of its elements present?
+func isEmptiable(comp *compositor, cmplx complexType) bool {
+	if comp.minOccurs == 0 {
+		return true
+	}
+	for _, p := range comp.parts {
+		empty := false
+		if p.comp != nil {
+			empty = isEmptiable(p.comp, cmplx)
+		} else if p.wild != nil {
+			empty = p.wild.minOccurs == 0
+		} else if el, ok := cmplx.findElem(p.elem); ok {
+			empty = el.minOccurs == 0
+		}
+		if comp.ctype == "choice" && empty {
+			return true
+		}
+		if comp.ctype != "choice" && !empty {
+			return false
+		}
+	}
+	return comp.ctype != "choice"
+}
+
+// write the constraints a compositor places on which properties are present
+// an optional compositor only applies if any of its elements are present:
+// "anyOf": [
+// {"not": {"anyOf": [{"required": ["Cd"]}, {"required": ["Prtry"]}]}},
+// { ...constraints... },
+// ],
+func writeCompositor(comp *compositor, cmplx complexType, f io.Writer, ctxt *context, indent int) {
+	if comp.minOccurs != 0 && (comp.ctype != "choice" || !isEmptiable(comp, cmplx)) {
+		writeCompositorBody(comp, cmplx, f, ctxt, indent)
+		return
+	}
+	if len(compElemNames(comp)) == 0 { // only wildcards, which may all be left out
+		return
+	}
+	inPrintf(f, indent, "\"anyOf\": [\n")
+	inPrintf(f, indent+tsz, "{\"not\": {\"anyOf\": [\n")
+	for _, name := range compElemNames(comp) {
+		inPrintf(f, indent+tsz+tsz, "{\"required\": [\"%s\"]},\n", name)
+	}
+	inPrintf(f, indent+tsz, "]}},\n")
+	inPrintf(f, indent+tsz, "{\n")
+	writeCompositorBody(comp, cmplx, f, ctxt, indent+tsz+tsz)
+	inPrintf(f, indent+tsz, "},\n")
+	inPrintf(f, indent, "],\n")
+}
+
+// write the constraints of a compositor, assuming it is present
+func writeCompositorBody(comp *compositor, cmplx complexType, f io.Writer, ctxt *context, indent int) {
+	switch comp.ctype {
 	case "choice":
 		// XSD choice maps to JSON schema thus:
 		// "oneOf": [
 		// {"required": ["Cd"] },
 		// {"required": ["Prtry"] },
 		// ],
-		inPrintf(f, indent, "\"oneOf\": [\n")
-		for _, el := range cmplx.elems {
-			inPrintf(f, indent+tsz, "{\"required\": [\"%s\"]},\n", el.getName())
+		// a repeating choice may use several alternatives, so is "anyOf",
+		// as is one whose alternatives share an element
+		// a nested compositor alternative is present if any of its elements are
+		// a wildcard alternative needs no named property, so it is taken
+		// when none of the others is present
+		keyword := "oneOf"
+		if occurs(comp.maxOccurs) > 1 || len(compElemNames(comp)) < countElems(comp) {
+			keyword = "anyOf"
+		}
+		inPrintf(f, indent, "\"%s\": [\n", keyword)
+		alternatives := make([]string, 0)
+		wildWritten := false
+		for _, p := range comp.parts {
+			if p.wild != nil || (p.comp != nil && len(compElemNames(p.comp)) == 0) {
+				switch names := compElemNames(comp); {
+				case wildWritten:
+				case len(names) == 0:
+					inPrintf(f, indent+tsz, "{},\n")
+				default:
+					inPrintf(f, indent+tsz, "{\"not\": {\"anyOf\": [%s]}},\n", requiredEach(names))
+				}
+				wildWritten = true
+				continue
+			}
+			if p.comp == nil {
+				if !containsString(alternatives, p.elem) {
+					inPrintf(f, indent+tsz, "{\"required\": [\"%s\"]},\n", p.elem)
+					alternatives = append(alternatives, p.elem)
+				}
+				continue
+			}
+			inPrintf(f, indent+tsz, "{\n")
+			inPrintf(f, indent+tsz+tsz, "\"anyOf\": [\n")
+			for _, name := range compElemNames(p.comp) {
+				inPrintf(f, indent+tsz+tsz+tsz, "{\"required\": [\"%s\"]},\n", name)
+			}
+			inPrintf(f, indent+tsz+tsz, "],\n")
+			inPrintf(f, indent+tsz+tsz, "\"allOf\": [{\n")
+			writeCompositor(p.comp, cmplx, f, ctxt, indent+tsz+tsz+tsz)
+			inPrintf(f, indent+tsz+tsz, "}],\n")
+			inPrintf(f, indent+tsz, "},\n")
 		}
 		inPrintf(f, indent, "],\n")
 
 	default:
+		// sequence or all: mandatory elements are required,
+		// nested compositors must all be satisfied
+		// all differs only in allowing any order, which JSON objects have anyway
+		if comp.ctype == "all" {
+			inPrintf(f, indent, "\"$comment\": \"XSD all: elements may occur in any order\",\n")
+		}
+		required := make([]string, 0)
+		nested := make([]*compositor, 0)
+		presence := make([]string, 0) // of the mandatory wildcards
+		for _, p := range comp.parts {
+			if p.comp != nil {
+				nested = append(nested, p.comp)
+			} else if p.wild != nil {
+				if schema := wildcardPresence(*p.wild, cmplx, ctxt); p.wild.minOccurs != 0 && schema != "" {
+					presence = append(presence, schema)
+				}
+			} else if el, ok := cmplx.findElem(p.elem); ok && el.minOccurs != 0 && !containsString(required, p.elem) {
+				required = append(required, p.elem)
+			}
+		}
 		if len(required) > 0 {
 			inPrintf(f, indent, "\"required\": %s,\n", arrayString(required))
 		}
-	}
-	if !cmplx.anyFlag {
-		inPrintf(f, indent, "\"additionalProperties\": false,\n")
-	} else {
-		inPrintf(f, indent, "\"$comment\": \"XSD allows 'any', so properties not restricted\",\n")
+		if len(nested)+len(presence) > 0 {
+			inPrintf(f, indent, "\"allOf\": [\n")
+			for _, n := range nested {
+				inPrintf(f, indent+tsz, "{\n")
+				writeCompositor(n, cmplx, f, ctxt, indent+tsz+tsz)
+				inPrintf(f, indent+tsz, "},\n")
+			}
+			for _, schema := range presence {
+				inPrintf(f, indent+tsz, "{%s},\n", schema)
+			}
+			inPrintf(f, indent, "],\n")
+		}
 	}
 }
 
@@ -246,22 +883,16 @@ func writeAttrs(attd attributed, f io.Writer, ctxt *context, indent int) []strin
 	attrs := attd.getAttrs()
 	required := []string{"#name"}
 	for _, attr := range attrs {
+		if attr.prohibited {
+			continue
+		}
 		if attr.required {
 			required = append(required, "@"+attr.name)
 		}
 		inPrintf(f, indent, "\"@%s\": {\n", attr.name)
+		writeAnnotations(attr.ann, f, ctxt, indent+tsz)
 		// atype must be either builtin or simple ...
-		if _, ok := ctxt.simpleTypes[attr.atype]; ok {
-			inPrintf(f, indent+tsz, "\"$ref\": \"#/definitions/%s\",\n", attr.atype)
-		} else {
-			inPrintf(f, indent+tsz, "\"type\": \"%s\",\n", attr.atype)
-		}
-		if attr.adefault != "" {
-			inPrintf(f, indent+tsz, "\"default\": \"%s\",\n", attr.adefault)
-		}
-		if attr.fixed != "" {
-			inPrintf(f, indent+tsz, "\"$comment\": \"XML specified fixed value %s\",\n", attr.fixed)
-		}
+		writeValue(attr.atype, attr.adefault, attr.fixed, f, ctxt, indent+tsz)
 		inPrintf(f, indent, "},\n")
 	}
 	return required
@@ -282,6 +913,13 @@ func inPrintf(f io.Writer, indent int, s string, v ...interface{}) (int, error)
 	return n1 + n2, err
 }
 
+// escape a string for use in JSON
+// double all slashes to make valid JSON escapes, and escape quotes
+func jsonEscape(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	return strings.Replace(s, "\"", "\\\"", -1)
+}
+
 func arrayString(strs []string) string {
 	s := "["
 	for _, val := range strs {
@@ -20,9 +20,12 @@
 package main
 
 import (
-	"strings"
+	"encoding/xml"
 )
 
+// the XML Schema namespace, home of the builtin types
+const xsdNs = "http://www.w3.org/2001/XMLSchema"
+
 var xtype2j = map[string]string{
 	// XML Schema Built-In Numeric Datatypes:
 	"decimal":            "number",
@@ -78,15 +81,133 @@ var xtype2j = map[string]string{
 	"notation":     "string",
 }
 
+// the builtin types that are whitespace separated lists, and their item types
+var xlistItems = map[string]string{
+	"NMTOKENS": "NMTOKEN",
+	"IDREFS":   "IDREF",
+	"ENTITIES": "ENTITY",
+}
+
+// the whiteSpace of the builtins that don't collapse it
+var xwhiteSpace = map[string]string{
+	"anyType":          "preserve",
+	"anySimpleType":    "preserve",
+	"string":           "preserve",
+	"normalizedString": "replace",
+}
+
+// the builtins derived from string, whose values whiteSpace normalises
+var xstringTypes = map[string]bool{
+	"string":           true,
+	"normalizedString": true,
+	"token":            true,
+	"language":         true,
+	"Name":             true,
+	"NCName":           true,
+	"NMTOKEN":          true,
+	"ID":               true,
+	"IDREF":            true,
+	"ENTITY":           true,
+}
+
+// pieces of the XSD date and time lexical spaces
+const (
+	xyear     = `-?(?:[1-9][0-9]{3,}|0[0-9]{3})`
+	xmonth    = `(?:0[1-9]|1[0-2])`
+	xday      = `(?:0[1-9]|[12][0-9]|3[01])`
+	xtime     = `(?:(?:[01][0-9]|2[0-3]):[0-5][0-9]:[0-5][0-9](?:\.[0-9]+)?|24:00:00(?:\.0+)?)`
+	xtimezone = `(?:Z|[+-](?:(?:0[0-9]|1[0-3]):[0-5][0-9]|14:00))`
+	xb64      = `[A-Za-z0-9+/] ?`
+)
+
+// the lexical space of builtins that JSON has no type for, as a pattern
+// the durations use lookaheads, which JSON schema (ECMA 262) patterns allow,
+// to need at least one field after P and after T
+var xpatterns = map[string]string{
+	"dateTime":          xyear + "-" + xmonth + "-" + xday + "T" + xtime + xtimezone + "?",
+	"dateTimeStamp":     xyear + "-" + xmonth + "-" + xday + "T" + xtime + xtimezone,
+	"date":              xyear + "-" + xmonth + "-" + xday + xtimezone + "?",
+	"time":              xtime + xtimezone + "?",
+	"gYearMonth":        xyear + "-" + xmonth + xtimezone + "?",
+	"gYear":             xyear + xtimezone + "?",
+	"gMonthDay":         "--" + xmonth + "-" + xday + xtimezone + "?",
+	"gDay":              "---" + xday + xtimezone + "?",
+	"gMonth":            "--" + xmonth + xtimezone + "?",
+	"duration":          `-?P(?!$)(?:[0-9]+Y)?(?:[0-9]+M)?(?:[0-9]+D)?(?:T(?!$)(?:[0-9]+H)?(?:[0-9]+M)?(?:[0-9]+(?:\.[0-9]+)?S)?)?`,
+	"dayTimeDuration":   `-?P(?!$)(?:[0-9]+D)?(?:T(?!$)(?:[0-9]+H)?(?:[0-9]+M)?(?:[0-9]+(?:\.[0-9]+)?S)?)?`,
+	"yearMonthDuration": `-?P(?!$)(?:[0-9]+Y)?(?:[0-9]+M)?`,
+	"hexBinary":         `(?:[0-9a-fA-F]{2})*`,
+	"base64Binary":      `(?:(?:(?:` + xb64 + `){4})*(?:(?:` + xb64 + `){3}[A-Za-z0-9+/]|(?:` + xb64 + `){2}[AEIMQUYcgkosw048] ?=|` + xb64 + `[AQgw] ?= ?=))?`,
+}
+
+// a JSON schema format, and the draft that introduced it
+type jsonFormat struct {
+	format string
+	draft  int
+}
+
+// the formats that accept every value of a builtin
+// date-time, date and time don't suit xs:dateTime, xs:date and xs:time,
+// as their timezone is optional, while RFC 3339 requires one on a time
+// and allows none on a date; nor does "uri" suit xs:anyURI, which may be relative
+var xformats = map[string]jsonFormat{
+	"dateTimeStamp": {"date-time", 4},
+	"anyURI":        {"uri-reference", 6},
+}
+
+// the contentEncoding of binary builtins, from draft 7
+var xencodings = map[string]string{
+	"base64Binary": "base64",
+	"hexBinary":    "base16",
+}
+
+// the keywords, besides "type" and "pattern", that describe the values of a
+// builtin in the draft being written, e.g. "format": "date-time"
+func builtinKeywords(qn xml.Name, ctxt *context) []string {
+	keywords := make([]string, 0)
+	if !isBuiltin(qn) {
+		return keywords
+	}
+	if f, ok := xformats[qn.Local]; ok && ctxt.draft >= f.draft {
+		keywords = append(keywords, "\"format\": \""+f.format+"\"")
+	}
+	if enc, ok := xencodings[qn.Local]; ok && ctxt.draft >= 7 {
+		keywords = append(keywords, "\"contentEncoding\": \""+enc+"\"")
+	}
+	return keywords
+}
+
+// the pattern for the lexical space of a builtin, "" if none
+func builtinPattern(qn xml.Name) string {
+	if !isBuiltin(qn) {
+		return ""
+	}
+	return xpatterns[qn.Local]
+}
+
+// the whiteSpace facet of a builtin type
+func builtinWhiteSpace(qn xml.Name) string {
+	if ws, ok := xwhiteSpace[qn.Local]; ok {
+		return ws
+	}
+	return "collapse"
+}
+
+// is this one of the XML Schema builtin types?
+func isBuiltin(qn xml.Name) bool {
+	return qn.Space == xsdNs
+}
+
 // map XML typenames to JSON
-func mapTypename(name string) (string, bool) {
-	idx := strings.Index(name, ":")
-	if idx > -1 {
-		name = name[idx+1:]
+// only names in the XML Schema namespace are builtins,
+// whatever prefix the schema author bound to it
+func mapTypename(qn xml.Name) (string, bool) {
+	if !isBuiltin(qn) {
+		return qn.Local, false
 	}
-	jname, mapped := xtype2j[name]
+	jname, mapped := xtype2j[qn.Local]
 	if mapped {
-		name = jname
+		return jname, true
 	}
-	return name, mapped
+	return qn.Local, false
 }